@@ -5,6 +5,21 @@ import (
 	"strings"
 )
 
+// builtinFunctions can be called from any template, unless Functions has one with the same name
+var builtinFunctions = map[string]func(args []any) (VarValue, error){
+	"len":     withoutError(length),
+	"join":    withoutError(join),
+	"range":   rangeFunction,
+	"groupBy": groupByFunction,
+}
+
+// withoutError adapts a Function to the builtinFunctions signature
+func withoutError(function Function) func(args []any) (VarValue, error) {
+	return func(args []any) (VarValue, error) {
+		return function(args...), nil
+	}
+}
+
 func length(args ...any) VarValue {
 	reflectValue := reflect.ValueOf(args[0])
 	if reflectValue.Kind() != reflect.Slice &&
@@ -0,0 +1,424 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	CHART_CONTENT_TYPE    = "application/vnd.openxmlformats-officedocument.drawingml.chart+xml"
+	WORKBOOK_CONTENT_TYPE = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+var ChartTypes = []string{"bar", "line", "pie", "scatter"}
+
+// ChartPars is the value of a CHART command
+type ChartPars struct {
+	Type       string // one of ChartTypes
+	Title      string // optional
+	Categories []string
+	Series     []ChartSeries
+	Width      float32 // in cm, defaults to 16
+	Height     float32 // in cm, defaults to 9
+}
+
+// ChartSeries is a named series of values of a chart, one value per category
+type ChartSeries struct {
+	Name   string
+	Values []float64
+}
+
+type Charts map[string]*ChartPars // [relId]
+
+func validateChartPars(pars *ChartPars) error {
+	if !slices.Contains(ChartTypes, pars.Type) {
+		return fmt.Errorf("A chart type (one of %v) needs to be provided", ChartTypes)
+	}
+	err := validateChartSeries(pars.Categories, pars.Series)
+	if err != nil {
+		return err
+	}
+	if pars.Type == "scatter" {
+		for _, category := range pars.Categories {
+			if _, err := strconv.ParseFloat(category, 64); err != nil {
+				return fmt.Errorf("The categories of a scatter chart must be numbers: %q", category)
+			}
+		}
+	}
+	return nil
+}
+
+// validateChartSeries checks that each series has one value per category
+func validateChartSeries(categories []string, series []ChartSeries) error {
+	if len(series) == 0 {
+		return fmt.Errorf("A chart needs at least one series")
+	}
+	for _, s := range series {
+		if len(s.Values) != len(categories) {
+			return fmt.Errorf("Series %q has %d values for %d categories", s.Name, len(s.Values), len(categories))
+		}
+	}
+	return nil
+}
+
+// chartParsFrom converts the result of a CHART expression
+func chartParsFrom(varValue VarValue) (*ChartPars, bool) {
+	switch value := varValue.(type) {
+	case *ChartPars:
+		return value, value != nil
+	case ChartPars:
+		return &value, true
+	}
+	return nil, false
+}
+
+// processChart adds a chart to the context, and inserts the `w:drawing` displaying it
+// in place of the command, like an image
+func processChart(ctx *Context, chartPars *ChartPars) error {
+	err := validateChartPars(chartPars)
+	if err != nil {
+		return err
+	}
+	chart := *chartPars
+	if chart.Width == 0 {
+		chart.Width = 16
+	}
+	if chart.Height == 0 {
+		chart.Height = 9
+	}
+	ctx.session.imageAndShapeIdIncrement += 1
+	id := fmt.Sprint(ctx.session.imageAndShapeIdIncrement)
+	relId := "chart" + id
+	ctx.charts[relId] = &chart
+
+	cx := fmt.Sprint(int(chart.Width * 360e3))
+	cy := fmt.Sprint(int(chart.Height * 360e3))
+	node := NewNonTextNode
+	drawing := node("w:drawing", map[string]string{}, []Node{
+		node("wp:inline", map[string]string{"distT": "0", "distB": "0", "distL": "0", "distR": "0"}, []Node{
+			node("wp:extent", map[string]string{"cx": cx, "cy": cy}, nil),
+			node("wp:docPr", map[string]string{"id": id, "name": "Chart " + id, "descr": chart.Title}, nil),
+			node("wp:cNvGraphicFramePr", map[string]string{}, nil),
+			node("a:graphic", map[string]string{"xmlns:a": "http://schemas.openxmlformats.org/drawingml/2006/main"}, []Node{
+				node("a:graphicData", map[string]string{"uri": "http://schemas.openxmlformats.org/drawingml/2006/chart"}, []Node{
+					node("c:chart", map[string]string{
+						"xmlns:c": "http://schemas.openxmlformats.org/drawingml/2006/chart",
+						"xmlns:r": "http://schemas.openxmlformats.org/officeDocument/2006/relationships",
+						"r:id":    relId,
+					}, nil),
+				}),
+			}),
+		}),
+	})
+	ctx.pendingImageNode = &struct {
+		image   *NonTextNode
+		caption []*NonTextNode
+	}{image: drawing}
+	return nil
+}
+
+// chartXmlOptions build the chart parts and their workbooks: they hold no literal XML,
+// so that the data is always escaped
+var chartXmlOptions = XmlOptions{}
+
+// ProcessCharts writes the chart parts of a document part with their embedded workbooks,
+// adds their relationships to the part rels, and returns the paths of the chart parts
+func ProcessCharts(charts Charts, documentComponent string, zip *ZipArchive) ([]string, error) {
+	slog.Debug("Processing charts for " + documentComponent + "...")
+	if len(charts) == 0 {
+		return nil, nil
+	}
+	relsPath := partRelsPath(documentComponent)
+	rels, err := getRelsFromZip(zip, relsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	chartPaths := []string{}
+	for _, chartId := range slices.Sorted(maps.Keys(charts)) {
+		chart := charts[chartId]
+		name := fmt.Sprintf("template_%s_%s", strings.ReplaceAll(documentComponent, "/", "_"), chartId)
+		chartPath := fmt.Sprintf("%s/charts/%s.xml", TEMPLATE_PATH, name)
+		slog.Debug("Writing chart " + chartId + " (" + chartPath + ")...")
+
+		workbook, err := chartWorkbook("Sheet1", chart.Categories, chart.Series, chart.Type == "scatter")
+		if err != nil {
+			return nil, err
+		}
+		zip.SetFile(fmt.Sprintf("%s/embeddings/%s.xlsx", TEMPLATE_PATH, name), workbook)
+		chartRels := NewNonTextNode("Relationships", map[string]string{"xmlns": "http://schemas.openxmlformats.org/package/2006/relationships"}, []Node{
+			NewNonTextNode("Relationship", map[string]string{
+				"Id":     "rId1",
+				"Type":   "http://schemas.openxmlformats.org/officeDocument/2006/relationships/package",
+				"Target": "../embeddings/" + name + ".xlsx",
+			}, nil),
+		})
+		zip.SetFile(partRelsPath("charts/"+name+".xml"), BuildXml(chartRels, chartXmlOptions, ""))
+		zip.SetFile(chartPath, BuildXml(chartSpace(chart), chartXmlOptions, ""))
+
+		AddChild(rels, NewNonTextNode("Relationship", map[string]string{
+			"Id":     chartId,
+			"Type":   "http://schemas.openxmlformats.org/officeDocument/2006/relationships/chart",
+			"Target": partTarget(documentComponent, "charts/"+name+".xml"),
+		}, nil))
+		chartPaths = append(chartPaths, chartPath)
+	}
+	zip.SetFile(relsPath, BuildXml(rels, chartXmlOptions, ""))
+	return chartPaths, nil
+}
+
+// columnName returns the name of a column of the chart workbook: A, B... Z, AA...
+func columnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+func formatChartValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// chartRef returns a c:strRef or c:numRef to cells of the chart workbook, with the cache of their values
+func chartRef(tag string, formula string, values []string, formatCode string) *NonTextNode {
+	node := NewNonTextNode
+	cache := []Node{}
+	if tag == "c:numRef" {
+		cache = append(cache, node("c:formatCode", nil, []Node{NewTextNode(formatCode)}))
+	}
+	cache = append(cache, node("c:ptCount", map[string]string{"val": strconv.Itoa(len(values))}, nil))
+	for i, value := range values {
+		cache = append(cache, node("c:pt", map[string]string{"idx": strconv.Itoa(i)}, []Node{
+			node("c:v", nil, []Node{NewTextNode(value)}),
+		}))
+	}
+	cacheTag := "c:strCache"
+	if tag == "c:numRef" {
+		cacheTag = "c:numCache"
+	}
+	return node(tag, nil, []Node{node("c:f", nil, []Node{NewTextNode(formula)}), node(cacheTag, nil, cache)})
+}
+
+// chartSpace returns the content of the chart part. The categories are in the first column of the
+// embedded workbook, and each series in the next ones, with its name in the first row.
+func chartSpace(chart *ChartPars) *NonTextNode {
+	node := NewNonTextNode
+	val := func(value string) map[string]string { return map[string]string{"val": value} }
+	count := len(chart.Categories)
+	lastRow := strconv.Itoa(count + 1)
+
+	categories := func(tag string) *NonTextNode {
+		ref := "Sheet1!$A$2:$A$" + lastRow
+		if chart.Type == "scatter" {
+			return node(tag, nil, []Node{chartRef("c:numRef", ref, chart.Categories, "General")})
+		}
+		return node(tag, nil, []Node{chartRef("c:strRef", ref, chart.Categories, "")})
+	}
+
+	series := []Node{}
+	for i, s := range chart.Series {
+		column := columnName(i + 1)
+		values := []string{}
+		for _, value := range s.Values {
+			values = append(values, formatChartValue(value))
+		}
+		children := []Node{
+			node("c:idx", val(strconv.Itoa(i)), nil),
+			node("c:order", val(strconv.Itoa(i)), nil),
+			node("c:tx", nil, []Node{chartRef("c:strRef", "Sheet1!$"+column+"$1", []string{s.Name}, "")}),
+		}
+		valuesRef := chartRef("c:numRef", "Sheet1!$"+column+"$2:$"+column+"$"+lastRow, values, "General")
+		switch chart.Type {
+		case "line":
+			children = append(children, categories("c:cat"), node("c:val", nil, []Node{valuesRef}), node("c:smooth", val("0"), nil))
+		case "scatter":
+			// markers only
+			children = append(children,
+				node("c:spPr", nil, []Node{node("a:ln", map[string]string{"w": "19050"}, []Node{node("a:noFill", nil, nil)})}),
+				categories("c:xVal"), node("c:yVal", nil, []Node{valuesRef}), node("c:smooth", val("0"), nil))
+		default:
+			children = append(children, categories("c:cat"), node("c:val", nil, []Node{valuesRef}))
+		}
+		series = append(series, node("c:ser", nil, children))
+	}
+
+	axisIds := []Node{node("c:axId", val("1"), nil), node("c:axId", val("2"), nil)}
+	axis := func(tag string, id string, crossId string, position string, extra ...Node) *NonTextNode {
+		children := []Node{
+			node("c:axId", val(id), nil),
+			node("c:scaling", nil, []Node{node("c:orientation", val("minMax"), nil)}),
+			node("c:delete", val("0"), nil),
+			node("c:axPos", val(position), nil),
+		}
+		if position == "l" {
+			children = append(children, node("c:majorGridlines", nil, nil))
+		}
+		children = append(children,
+			node("c:numFmt", map[string]string{"formatCode": "General", "sourceLinked": "1"}, nil),
+			node("c:tickLblPos", val("nextTo"), nil),
+			node("c:crossAx", val(crossId), nil),
+			node("c:crosses", val("autoZero"), nil),
+		)
+		return node(tag, nil, append(children, extra...))
+	}
+	categoryAxis := axis("c:catAx", "1", "2", "b", node("c:auto", val("1"), nil), node("c:lblAlgn", val("ctr"), nil), node("c:lblOffset", val("100"), nil))
+	valueAxis := axis("c:valAx", "2", "1", "l", node("c:crossBetween", val("between"), nil))
+
+	var plot []Node
+	switch chart.Type {
+	case "bar":
+		plot = []Node{
+			node("c:barChart", nil, slices.Concat(
+				[]Node{node("c:barDir", val("col"), nil), node("c:grouping", val("clustered"), nil), node("c:varyColors", val("0"), nil)},
+				series,
+				[]Node{node("c:gapWidth", val("150"), nil)},
+				axisIds,
+			)),
+			categoryAxis, valueAxis,
+		}
+	case "line":
+		plot = []Node{
+			node("c:lineChart", nil, slices.Concat(
+				[]Node{node("c:grouping", val("standard"), nil), node("c:varyColors", val("0"), nil)},
+				series,
+				[]Node{node("c:marker", val("1"), nil)},
+				axisIds,
+			)),
+			categoryAxis, valueAxis,
+		}
+	case "pie":
+		plot = []Node{
+			node("c:pieChart", nil, slices.Concat(
+				[]Node{node("c:varyColors", val("1"), nil)},
+				series,
+				[]Node{node("c:firstSliceAng", val("0"), nil)},
+			)),
+		}
+	case "scatter":
+		plot = []Node{
+			node("c:scatterChart", nil, slices.Concat(
+				[]Node{node("c:scatterStyle", val("lineMarker"), nil), node("c:varyColors", val("0"), nil)},
+				series,
+				axisIds,
+			)),
+			axis("c:valAx", "1", "2", "b", node("c:crossBetween", val("midCat"), nil)),
+			valueAxis,
+		}
+	}
+
+	chartChildren := []Node{}
+	if chart.Title != "" {
+		chartChildren = append(chartChildren,
+			node("c:title", nil, []Node{
+				node("c:tx", nil, []Node{node("c:rich", nil, []Node{
+					node("a:bodyPr", nil, nil),
+					node("a:p", nil, []Node{node("a:r", nil, []Node{node("a:t", nil, []Node{NewTextNode(chart.Title)})})}),
+				})}),
+				node("c:overlay", val("0"), nil),
+			}),
+			node("c:autoTitleDeleted", val("0"), nil),
+		)
+	} else {
+		chartChildren = append(chartChildren, node("c:autoTitleDeleted", val("1"), nil))
+	}
+	chartChildren = append(chartChildren,
+		node("c:plotArea", nil, append([]Node{node("c:layout", nil, nil)}, plot...)),
+		node("c:legend", nil, []Node{node("c:legendPos", val("b"), nil), node("c:overlay", val("0"), nil)}),
+		node("c:plotVisOnly", val("1"), nil),
+		node("c:dispBlanksAs", val("gap"), nil),
+	)
+
+	return node("c:chartSpace", map[string]string{
+		"xmlns:c": "http://schemas.openxmlformats.org/drawingml/2006/chart",
+		"xmlns:a": "http://schemas.openxmlformats.org/drawingml/2006/main",
+		"xmlns:r": "http://schemas.openxmlformats.org/officeDocument/2006/relationships",
+	}, []Node{
+		node("c:roundedCorners", val("0"), nil),
+		node("c:chart", nil, chartChildren),
+		node("c:externalData", map[string]string{"r:id": "rId1"}, []Node{node("c:autoUpdate", val("0"), nil)}),
+	})
+}
+
+var workbookParts = map[string]string{
+	"[Content_Types].xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`,
+	"_rels/.rels": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`,
+	"xl/_rels/workbook.xml.rels": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`,
+}
+
+// chartWorkbook returns the xlsx workbook holding the data of a chart, so that it can be edited in Word
+func chartWorkbook(sheetName string, categories []string, series []ChartSeries, numericCategories bool) ([]byte, error) {
+	node := NewNonTextNode
+	textCell := func(ref string, text string) Node {
+		return node("c", map[string]string{"r": ref, "t": "inlineStr"}, []Node{node("is", nil, []Node{node("t", nil, []Node{NewTextNode(text)})})})
+	}
+	numberCell := func(ref string, value string) Node {
+		return node("c", map[string]string{"r": ref}, []Node{node("v", nil, []Node{NewTextNode(value)})})
+	}
+
+	header := []Node{}
+	for i, s := range series {
+		header = append(header, textCell(columnName(i+1)+"1", s.Name))
+	}
+	rows := []Node{node("row", map[string]string{"r": "1"}, header)}
+	for i, category := range categories {
+		row := strconv.Itoa(i + 2)
+		cells := []Node{}
+		if numericCategories {
+			cells = append(cells, numberCell("A"+row, category))
+		} else {
+			cells = append(cells, textCell("A"+row, category))
+		}
+		for j, s := range series {
+			cells = append(cells, numberCell(columnName(j+1)+row, formatChartValue(s.Values[i])))
+		}
+		rows = append(rows, node("row", map[string]string{"r": row}, cells))
+	}
+	sheet := node("worksheet", map[string]string{"xmlns": "http://schemas.openxmlformats.org/spreadsheetml/2006/main"}, []Node{
+		node("sheetData", nil, rows),
+	})
+
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	workbook := node("workbook", map[string]string{
+		"xmlns":   "http://schemas.openxmlformats.org/spreadsheetml/2006/main",
+		"xmlns:r": "http://schemas.openxmlformats.org/officeDocument/2006/relationships",
+	}, []Node{
+		node("sheets", nil, []Node{node("sheet", map[string]string{"name": sheetName, "sheetId": "1", "r:id": "rId1"}, nil)}),
+	})
+
+	files := maps.Clone(workbookParts)
+	files["xl/workbook.xml"] = string(BuildXml(workbook, chartXmlOptions, ""))
+	files["xl/worksheets/sheet1.xml"] = string(BuildXml(sheet, chartXmlOptions, ""))
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		file, err := writer.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.Write([]byte(files[name])); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CHART_DATA_PREFIX starts the alt text or title of a chart of the template bound to data,
+// followed by the expression giving its ChartData, e.g. chart:salesByMonth
+const CHART_DATA_PREFIX = "chart:"
+
+// ChartData is the data of a chart of the template: its formatting is kept,
+// its categories and series are replaced
+type ChartData struct {
+	Categories []string
+	Series     []ChartSeries
+}
+
+type ChartUpdates map[string]*ChartData // [relId]
+
+// boundChartData returns the data of the chart bound by the alt text or title of a wp:docPr, if any
+func boundChartData(docPr *NonTextNode, ctx *Context, data any) (*ChartData, error) {
+	for _, attr := range []string{"descr", "title"} {
+		binding, ok := strings.CutPrefix(strings.TrimSpace(docPr.Attrs[attr]), CHART_DATA_PREFIX)
+		if !ok {
+			continue
+		}
+		varValue, err := runAndGetValue(binding, ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		var chartData *ChartData
+		switch value := varValue.(type) {
+		case *ChartData:
+			chartData = value
+		case ChartData:
+			chartData = &value
+		}
+		if chartData == nil {
+			return nil, errors.New("Not chart data as result of " + binding)
+		}
+		if err := validateChartSeries(chartData.Categories, chartData.Series); err != nil {
+			return nil, fmt.Errorf("ChartError: %w", err)
+		}
+		return chartData, nil
+	}
+	return nil, nil
+}
+
+// relationshipPath returns the path in the archive of the target of a relationship of a part
+func relationshipPath(part string, target string) string {
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimPrefix(target, "/")
+	}
+	return path.Join(path.Dir(part), target)
+}
+
+// relationshipTarget returns the path in the archive of the target of a relationship, given by id or by type
+func relationshipTarget(zip *ZipArchive, part string, match func(rel *NonTextNode) bool) (string, error) {
+	rels, err := getRelsFromZip(zip, path.Join(path.Dir(part), "_rels", path.Base(part)+".rels"))
+	if err != nil {
+		return "", err
+	}
+	for _, child := range rels.Children() {
+		if rel, ok := child.(*NonTextNode); ok && match(rel) {
+			return relationshipPath(part, rel.Attrs["Target"]), nil
+		}
+	}
+	return "", nil
+}
+
+// UpdateCharts replaces the categories and series of the template charts bound to data
+// in the chart parts of a document part, and their embedded workbooks
+func UpdateCharts(updates ChartUpdates, documentComponent string, zip *ZipArchive) error {
+	part := path.Join(TEMPLATE_PATH, documentComponent)
+	for relId, chartData := range updates {
+		chartPath, err := relationshipTarget(zip, part, func(rel *NonTextNode) bool { return rel.Attrs["Id"] == relId })
+		if err != nil {
+			return err
+		}
+		if chartPath == "" {
+			return fmt.Errorf("Chart %s of %s not found", relId, documentComponent)
+		}
+		slog.Debug("Updating chart " + chartPath + "...")
+		chartXml, err := zip.GetFile(chartPath)
+		if err != nil {
+			return err
+		}
+		root, err := ParseXml(string(chartXml))
+		if err != nil {
+			return err
+		}
+		sheetName, numericCategories, err := updateChartSeries(root, chartData)
+		if err != nil {
+			return fmt.Errorf("Chart %s: %w", chartPath, err)
+		}
+		zip.SetFile(chartPath, BuildXml(root, chartXmlOptions, ""))
+
+		workbookPath, err := relationshipTarget(zip, chartPath, func(rel *NonTextNode) bool {
+			return strings.HasSuffix(rel.Attrs["Type"], "/package") && strings.HasSuffix(rel.Attrs["Target"], ".xlsx")
+		})
+		if err != nil {
+			return err
+		}
+		if workbookPath != "" {
+			workbookSheet := strings.ReplaceAll(strings.Trim(sheetName, "'"), "''", "'")
+			workbook, err := chartWorkbook(workbookSheet, chartData.Categories, chartData.Series, numericCategories)
+			if err != nil {
+				return err
+			}
+			zip.SetFile(workbookPath, workbook)
+		}
+	}
+	return nil
+}
+
+// descendants returns the elements with the given tag under a node, in document order
+func descendants(node Node, tag string) []*NonTextNode {
+	found := []*NonTextNode{}
+	for _, child := range node.Children() {
+		if element, ok := child.(*NonTextNode); ok {
+			if element.Tag == tag {
+				found = append(found, element)
+			}
+			found = append(found, descendants(element, tag)...)
+		}
+	}
+	return found
+}
+
+func firstChildElement(node Node, tags ...string) *NonTextNode {
+	for _, child := range node.Children() {
+		if element, ok := child.(*NonTextNode); ok && slices.Contains(tags, element.Tag) {
+			return element
+		}
+	}
+	return nil
+}
+
+// updateChartSeries replaces the categories and series of a chart part, keeping the formatting of its series.
+// Series are added by copying the last one, without its colors. It returns the name of the sheet of the
+// embedded workbook, and whether the categories are numbers.
+func updateChartSeries(chartSpace Node, chartData *ChartData) (string, bool, error) {
+	series := descendants(chartSpace, "c:ser")
+	if len(series) == 0 {
+		return "", false, errors.New("the chart has no series")
+	}
+
+	sheetName := "Sheet1"
+	for _, formula := range descendants(series[0], "c:f") {
+		if text := nodeText(formula); strings.Contains(text, "!") {
+			sheetName = text[:strings.LastIndex(text, "!")]
+			break
+		}
+	}
+
+	// adjust the number of series
+	for len(series) > len(chartData.Series) {
+		last := series[len(series)-1]
+		parent := last.Parent()
+		parent.SetChildren(slices.DeleteFunc(slices.Clone(parent.Children()), func(child Node) bool { return child == last }))
+		series = series[:len(series)-1]
+	}
+	nextIdx := 0
+	for _, ser := range series {
+		for _, tag := range []string{"c:idx", "c:order"} {
+			if element := firstChildElement(ser, tag); element != nil {
+				if idx, err := strconv.Atoi(element.Attrs["val"]); err == nil {
+					nextIdx = max(nextIdx, idx+1)
+				}
+			}
+		}
+	}
+	for len(series) < len(chartData.Series) {
+		last := series[len(series)-1]
+		ser := CloneNode(last).(*NonTextNode)
+		ser.SetChildren(slices.DeleteFunc(slices.Clone(ser.Children()), func(child Node) bool {
+			element, ok := child.(*NonTextNode)
+			return ok && (element.Tag == "c:spPr" || element.Tag == "c:dPt")
+		}))
+		for _, tag := range []string{"c:idx", "c:order"} {
+			if element := firstChildElement(ser, tag); element != nil {
+				element.Attrs["val"] = strconv.Itoa(nextIdx)
+			}
+		}
+		nextIdx++
+		parent := last.Parent()
+		children := parent.Children()
+		index := slices.Index(children, Node(last))
+		ser.SetParent(parent)
+		parent.SetChildren(slices.Insert(slices.Clone(children), index+1, Node(ser)))
+		series = append(series, ser)
+	}
+
+	lastRow := strconv.Itoa(len(chartData.Categories) + 1)
+	numericCategories := false
+	for i, ser := range series {
+		s := chartData.Series[i]
+		column := columnName(i + 1)
+		if tx := firstChildElement(ser, "c:tx"); tx != nil {
+			replaceChartRef(tx, sheetName+"!$"+column+"$1", []string{s.Name}, false)
+		}
+		if categories := firstChildElement(ser, "c:cat", "c:xVal"); categories != nil {
+			numericCategories = replaceChartRef(categories, sheetName+"!$A$2:$A$"+lastRow, chartData.Categories, true)
+		}
+		values := []string{}
+		for _, value := range s.Values {
+			values = append(values, formatChartValue(value))
+		}
+		if valuesRef := firstChildElement(ser, "c:val", "c:yVal"); valuesRef != nil {
+			replaceChartRef(valuesRef, sheetName+"!$"+column+"$2:$"+column+"$"+lastRow, values, true)
+		}
+	}
+	return sheetName, numericCategories, nil
+}
+
+// replaceChartRef replaces the reference of a series name, categories or values.
+// Numbers stay numbers (with their format) if allowed and all the values are numbers.
+// It returns whether the values are numbers.
+func replaceChartRef(container *NonTextNode, formula string, values []string, allowNumbers bool) bool {
+	old := firstChildElement(container, "c:strRef", "c:numRef", "c:strLit", "c:numLit", "c:multiLvlStrRef")
+	numeric := allowNumbers && old != nil && (old.Tag == "c:numRef" || old.Tag == "c:numLit")
+	for _, value := range values {
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			numeric = false
+		}
+	}
+	formatCode := "General"
+	if old != nil {
+		if code := descendants(old, "c:formatCode"); len(code) > 0 {
+			formatCode = nodeText(code[0])
+		}
+	}
+	ref := chartRef("c:strRef", formula, values, "")
+	if numeric {
+		ref = chartRef("c:numRef", formula, values, formatCode)
+	}
+	ref.SetParent(container)
+	children := slices.DeleteFunc(slices.Clone(container.Children()), func(child Node) bool {
+		_, ok := child.(*NonTextNode)
+		return ok
+	})
+	container.SetChildren(append(children, ref))
+	return numeric
+}
+
+// nodeText returns the text of a node
+func nodeText(node Node) string {
+	var text strings.Builder
+	for _, child := range node.Children() {
+		switch child := child.(type) {
+		case *TextNode:
+			text.WriteString(child.Text)
+		case *NonTextNode:
+			text.WriteString(nodeText(child))
+		}
+	}
+	return text.String()
+}
@@ -33,3 +33,44 @@ type KeyNotFoundError struct {
 func (e *KeyNotFoundError) Error() string {
 	return fmt.Sprintf("Key not found: %s", e.Key)
 }
+
+type WalkingDepthExceededError struct {
+	Limit int
+}
+
+func (e *WalkingDepthExceededError) Error() string {
+	return fmt.Sprintf("Maximum walking depth exceeded (%d steps): infinite loop or massive dataset detected. Please review and try again", e.Limit)
+}
+
+type LoopIterationsExceededError struct {
+	Limit int
+}
+
+func (e *LoopIterationsExceededError) Error() string {
+	return fmt.Sprintf("Maximum loop iterations exceeded: %d", e.Limit)
+}
+
+type OutputSizeExceededError struct {
+	Limit int64
+	Size  int64
+}
+
+func (e *OutputSizeExceededError) Error() string {
+	return fmt.Sprintf("Maximum output size exceeded: %d bytes (limit %d)", e.Size, e.Limit)
+}
+
+type StyleNotFoundError struct {
+	Style string
+}
+
+func (e *StyleNotFoundError) Error() string {
+	return fmt.Sprintf("Style not found in the template: %s", e.Style)
+}
+
+type ImageBytesExceededError struct {
+	Limit int64
+}
+
+func (e *ImageBytesExceededError) Error() string {
+	return fmt.Sprintf("Maximum image bytes exceeded: %d", e.Limit)
+}
@@ -0,0 +1,832 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Expressions are used by INS, IF, FOR and the other commands taking a value.
+//
+// They support literals ('text', "text", `text`, 12, 1.5, true, false, null),
+// data paths (a.b, $var.b, optional a?.b), function calls with nested calls
+// (upper(trim($p.name))), parentheses, and the following operators, from
+// lowest to highest precedence:
+//
+//	c ? a : b    ternary
+//	a ?? b       null-coalescing (b when a is missing or null)
+//	a || b       logical or (returns the first truthy operand)
+//	a && b       logical and (returns the first falsy operand)
+//	== !=        equality
+//	< <= > >=    comparison
+//	+ -          addition, string concatenation, subtraction
+//	* / %        multiplication, division, modulo
+//	! -          logical not, negation
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenPath
+	tokenOperator
+)
+
+type exprToken struct {
+	kind  tokenKind
+	text  string
+	value VarValue // for numbers and strings
+	pos   int
+}
+
+type exprNode interface{}
+
+type literalExpr struct {
+	value VarValue
+}
+
+type pathExpr struct {
+	path     string
+	segments []pathSegment // nil if the path is invalid
+}
+
+type callExpr struct {
+	name string
+	args []exprNode
+}
+
+type unaryExpr struct {
+	op      string
+	operand exprNode
+}
+
+type binaryExpr struct {
+	op          string
+	left, right exprNode
+}
+
+type ternaryExpr struct {
+	cond, then, otherwise exprNode
+}
+
+// maxParseCacheEntries bounds the number of expressions, and of FOR clauses, cached for a template,
+// as expressions can also be built from the data (e.g. by HTML interpolation)
+const maxParseCacheEntries = 10000
+
+// ParseCache caches the parsed expressions and FOR clauses of a prepared template, by text
+type ParseCache struct {
+	mu          sync.Mutex
+	expressions map[string]exprNode
+	loopClauses map[string]*loopClauses
+}
+
+func NewParseCache() *ParseCache {
+	return &ParseCache{
+		expressions: make(map[string]exprNode),
+		loopClauses: make(map[string]*loopClauses),
+	}
+}
+
+func (c *ParseCache) expression(text string) (exprNode, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node, ok := c.expressions[text]
+	return node, ok
+}
+
+func (c *ParseCache) storeExpression(text string, node exprNode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.expressions) < maxParseCacheEntries {
+		c.expressions[text] = node
+	}
+}
+
+// parseExpression parses (or gets from the cache) the AST of an expression
+func parseExpression(text string, cache *ParseCache) (exprNode, error) {
+	if cached, ok := cache.expression(text); ok {
+		return cached, nil
+	}
+	tokens, err := tokenizeExpression(text)
+	if err != nil {
+		return nil, err
+	}
+	parser := &exprParser{text: text, tokens: tokens}
+	node, err := parser.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if tok := parser.peek(); tok.kind != tokenEOF {
+		return nil, parser.errorAt(tok, "unexpected "+tok.text)
+	}
+	cache.storeExpression(text, node)
+	return node, nil
+}
+
+func runAndGetValue(text string, ctx *Context, data any) (VarValue, error) {
+	node, err := parseExpression(strings.TrimSpace(text), ctx.session.parseCache)
+	if err != nil {
+		return nil, err
+	}
+	return evalExpr(node, ctx, data)
+}
+
+// =============================================
+// Tokenizer
+// =============================================
+
+var twoCharOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "??"}
+
+const singleCharOperators = "<>+-*/%!(),?:"
+
+func isPathStart(c rune) bool {
+	return unicode.IsLetter(c) || c == '_' || c == '$'
+}
+
+func isPathChar(c rune) bool {
+	return isPathStart(c) || unicode.IsDigit(c)
+}
+
+func tokenizeExpression(text string) ([]exprToken, error) {
+	runes := []rune(text)
+	tokens := []exprToken{}
+	i := 0
+
+	for i < len(runes) {
+		c := runes[i]
+		start := i
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case unicode.IsDigit(c):
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			isFloat := false
+			if i+1 < len(runes) && runes[i] == '.' && unicode.IsDigit(runes[i+1]) {
+				isFloat = true
+				i++
+				for i < len(runes) && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+			numberText := string(runes[start:i])
+			var value VarValue
+			if isFloat {
+				value, _ = strconv.ParseFloat(numberText, 64)
+			} else if intValue, err := strconv.ParseInt(numberText, 10, 64); err == nil {
+				value = intValue
+			} else {
+				value, _ = strconv.ParseFloat(numberText, 64)
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: numberText, value: value, pos: start})
+
+		case c == '\'' || c == '"' || c == '`':
+			str, next, err := scanString(runes, i)
+			if err != nil {
+				return nil, NewInvalidCommandError(err.Error(), text)
+			}
+			i = next
+			tokens = append(tokens, exprToken{kind: tokenString, text: string(runes[start:i]), value: str, pos: start})
+
+		case isPathStart(c):
+			i = scanPath(runes, i)
+			tokens = append(tokens, exprToken{kind: tokenPath, text: string(runes[start:i]), pos: start})
+
+		default:
+			if i+1 < len(runes) {
+				pair := string(runes[i : i+2])
+				if slices.Contains(twoCharOperators, pair) {
+					tokens = append(tokens, exprToken{kind: tokenOperator, text: pair, pos: start})
+					i += 2
+					continue
+				}
+			}
+			if strings.ContainsRune(singleCharOperators, c) {
+				tokens = append(tokens, exprToken{kind: tokenOperator, text: string(c), pos: start})
+				i++
+				continue
+			}
+			return nil, NewInvalidCommandError(fmt.Sprintf("Unexpected character '%c' at position %d", c, start), text)
+		}
+	}
+	tokens = append(tokens, exprToken{kind: tokenEOF, text: "end of expression", pos: len(runes)})
+	return tokens, nil
+}
+
+// scanString reads a quoted string starting at runes[start].
+// Single and double quoted strings support backslash escapes, backquoted strings are raw.
+func scanString(runes []rune, start int) (string, int, error) {
+	quote := runes[start]
+	var builder strings.Builder
+	i := start + 1
+	for i < len(runes) {
+		c := runes[i]
+		if c == quote {
+			return builder.String(), i + 1, nil
+		}
+		if c == '\\' && quote != '`' && i+1 < len(runes) {
+			i++
+			switch runes[i] {
+			case 'n':
+				builder.WriteRune('\n')
+			case 't':
+				builder.WriteRune('\t')
+			default:
+				builder.WriteRune(runes[i])
+			}
+		} else {
+			builder.WriteRune(c)
+		}
+		i++
+	}
+	return "", 0, fmt.Errorf("Unterminated string starting at position %d", start)
+}
+
+// scanPath reads a data path (e.g. `$person.address?.city`, `people[0].name`) starting at runes[start],
+// and returns the position right after it.
+func scanPath(runes []rune, start int) int {
+	i := start
+	for i < len(runes) {
+		c := runes[i]
+		switch {
+		case isPathChar(c):
+			i++
+		case c == '.' && i+1 < len(runes) && isPathChar(runes[i+1]):
+			i++
+		// Index, key or slice: `[0]`, `[-1]`, `['key']`, `[1:3]`
+		case c == '[':
+			end := findClosingBracket(string(runes[i:]), 0)
+			if end < 0 {
+				return i
+			}
+			i += len([]rune(string(runes[i:])[:end])) + 1
+		// Keys containing dashes (e.g. `first-name`) are allowed, a subtraction needs spaces
+		case c == '-' && i+1 < len(runes) && (unicode.IsLetter(runes[i+1]) || runes[i+1] == '_'):
+			i++
+		case c == '?' && isOptionalMarker(runes, i):
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// isOptionalMarker tells whether the `?` at runes[i], right after a path segment,
+// marks the segment as optional (`a?.b`, `a?`) rather than starting a ternary or `??`.
+func isOptionalMarker(runes []rune, i int) bool {
+	if i+1 >= len(runes) {
+		return true
+	}
+	next := runes[i+1]
+	if next == '.' || next == '[' {
+		return true
+	}
+	if next == '?' {
+		return false
+	}
+	j := i + 1
+	for j < len(runes) && unicode.IsSpace(runes[j]) {
+		j++
+	}
+	if j >= len(runes) {
+		return true
+	}
+	rest := string(runes[j:min(j+2, len(runes))])
+	return strings.ContainsRune("),:]=<>&|+*/%", runes[j]) || rest == "!=" || rest == "??"
+}
+
+// =============================================
+// Parser
+// =============================================
+
+type exprParser struct {
+	text   string
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) acceptOperator(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind == tokenOperator && slices.Contains(ops, tok.text) {
+		p.pos++
+		return tok.text, true
+	}
+	return "", false
+}
+
+func (p *exprParser) expectOperator(op string) error {
+	if _, ok := p.acceptOperator(op); !ok {
+		tok := p.peek()
+		return p.errorAt(tok, fmt.Sprintf("expected '%s' but found %s", op, tok.text))
+	}
+	return nil
+}
+
+func (p *exprParser) errorAt(tok exprToken, message string) error {
+	return NewInvalidCommandError(fmt.Sprintf("Invalid expression (%s at position %d)", message, tok.pos), p.text)
+}
+
+func (p *exprParser) parseTernary() (exprNode, error) {
+	cond, err := p.parseCoalesce()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.acceptOperator("?"); !ok {
+		return cond, nil
+	}
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectOperator(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return &ternaryExpr{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+// parseBinary parses a left-associative chain of operators of the same precedence
+func (p *exprParser) parseBinary(operand func() (exprNode, error), ops ...string) (exprNode, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOperator(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseCoalesce() (exprNode, error) {
+	return p.parseBinary(p.parseOr, "??")
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseBinary(p.parseEquality, "&&")
+}
+
+func (p *exprParser) parseEquality() (exprNode, error) {
+	return p.parseBinary(p.parseComparison, "==", "!=")
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	return p.parseBinary(p.parseAdditive, "<", "<=", ">", ">=")
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if op, ok := p.acceptOperator("!", "-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNumber, tokenString:
+		return &literalExpr{value: tok.value}, nil
+
+	case tokenPath:
+		switch tok.text {
+		case "true":
+			return &literalExpr{value: true}, nil
+		case "false":
+			return &literalExpr{value: false}, nil
+		case "null", "nil":
+			return &literalExpr{value: nil}, nil
+		}
+		if _, ok := p.acceptOperator("("); ok {
+			return p.parseCall(tok)
+		}
+		segments, _ := splitPath(tok.text)
+		return &pathExpr{path: tok.text, segments: segments}, nil
+
+	case tokenOperator:
+		if tok.text == "(" {
+			inner, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOperator(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+	}
+	return nil, p.errorAt(tok, "unexpected "+tok.text)
+}
+
+func (p *exprParser) parseCall(nameToken exprToken) (exprNode, error) {
+	if strings.ContainsAny(nameToken.text, ".$?") {
+		return nil, p.errorAt(nameToken, "invalid function name "+nameToken.text)
+	}
+	call := &callExpr{name: nameToken.text, args: []exprNode{}}
+	if _, ok := p.acceptOperator(")"); ok {
+		return call, nil
+	}
+	for {
+		arg, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+		if _, ok := p.acceptOperator(","); ok {
+			continue
+		}
+		if err := p.expectOperator(")"); err != nil {
+			return nil, err
+		}
+		return call, nil
+	}
+}
+
+// =============================================
+// Evaluator
+// =============================================
+
+func evalExpr(node exprNode, ctx *Context, data any) (VarValue, error) {
+	switch n := node.(type) {
+	case *literalExpr:
+		return n.value, nil
+
+	case *pathExpr:
+		if value, ok, _ := lookupPath(n, ctx, data); ok {
+			return value, nil
+		}
+		if ctx.options.ErrorHandler != nil {
+			return ctx.options.ErrorHandler(&KeyNotFoundError{Key: n.path}, n.path), nil
+		}
+		return nil, &KeyNotFoundError{Key: n.path}
+
+	case *callExpr:
+		args := make([]any, len(n.args))
+		for i, arg := range n.args {
+			value, err := evalExpr(arg, ctx, data)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = value
+		}
+		return runFunction(n.name, args, ctx)
+
+	case *unaryExpr:
+		operand, err := evalExpr(n.operand, ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "!" {
+			return !isTruthy(operand), nil
+		}
+		if integer, ok := toInteger(operand); ok {
+			return -integer, nil
+		}
+		if number, ok := toNumber(operand); ok {
+			return -number, nil
+		}
+		return nil, fmt.Errorf("Cannot negate %v", operand)
+
+	case *ternaryExpr:
+		cond, err := evalExpr(n.cond, ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		if isTruthy(cond) {
+			return evalExpr(n.then, ctx, data)
+		}
+		return evalExpr(n.otherwise, ctx, data)
+
+	case *binaryExpr:
+		return evalBinary(n, ctx, data)
+	}
+	return nil, fmt.Errorf("Unknown expression node %T", node)
+}
+
+// lookupPath resolves a data path, or a path of the loop variables when it starts with `$`
+// (see resolvePath)
+func lookupPath(path *pathExpr, ctx *Context, data any) (value VarValue, ok bool, missingOptional bool) {
+	if path.segments == nil {
+		return "", false, false
+	}
+	if path.path[0] == '$' {
+		return resolveSegments(path.segments, ctx.vars)
+	}
+	return resolveSegments(path.segments, data)
+}
+
+func evalBinary(n *binaryExpr, ctx *Context, data any) (VarValue, error) {
+	// Short-circuit operators
+	switch n.op {
+	case "??":
+		if path, isPath := n.left.(*pathExpr); isPath {
+			// a missing optional path is missing too, rather than an empty string
+			if value, ok, missingOptional := lookupPath(path, ctx, data); ok && !missingOptional && value != nil {
+				return value, nil
+			}
+			return evalExpr(n.right, ctx, data)
+		}
+		left, err := evalExpr(n.left, ctx, data)
+		if err != nil || left != nil {
+			return left, err
+		}
+		return evalExpr(n.right, ctx, data)
+	case "||", "&&":
+		left, err := evalExpr(n.left, ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		if isTruthy(left) == (n.op == "||") {
+			return left, nil
+		}
+		return evalExpr(n.right, ctx, data)
+	}
+
+	left, err := evalExpr(n.left, ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	right, err := evalExpr(n.right, ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return valuesEqual(left, right), nil
+	case "!=":
+		return !valuesEqual(left, right), nil
+	case "<", "<=", ">", ">=":
+		cmp, err := compareValues(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	default:
+		return evalArithmetic(n.op, left, right)
+	}
+}
+
+func evalArithmetic(op string, left, right VarValue) (VarValue, error) {
+	if op == "+" {
+		_, leftIsString := left.(string)
+		_, rightIsString := right.(string)
+		if leftIsString || rightIsString {
+			return toString(left) + toString(right), nil
+		}
+	}
+
+	leftInt, leftIsInt := toInteger(left)
+	rightInt, rightIsInt := toInteger(right)
+	if leftIsInt && rightIsInt && op != "/" {
+		switch op {
+		case "+":
+			return leftInt + rightInt, nil
+		case "-":
+			return leftInt - rightInt, nil
+		case "*":
+			return leftInt * rightInt, nil
+		case "%":
+			if rightInt == 0 {
+				return nil, fmt.Errorf("Modulo by zero")
+			}
+			return leftInt % rightInt, nil
+		}
+	}
+
+	leftNum, leftIsNum := toNumber(left)
+	rightNum, rightIsNum := toNumber(right)
+	if !leftIsNum || !rightIsNum {
+		return nil, fmt.Errorf("Invalid operands for %s: %v and %v", op, left, right)
+	}
+	switch op {
+	case "+":
+		return leftNum + rightNum, nil
+	case "-":
+		return leftNum - rightNum, nil
+	case "*":
+		return leftNum * rightNum, nil
+	case "/":
+		if rightNum == 0 {
+			return nil, fmt.Errorf("Division by zero")
+		}
+		return leftNum / rightNum, nil
+	case "%":
+		if rightNum == 0 {
+			return nil, fmt.Errorf("Modulo by zero")
+		}
+		return math.Mod(leftNum, rightNum), nil
+	}
+	return nil, fmt.Errorf("Unknown operator %s", op)
+}
+
+func runFunction(funcName string, args []any, ctx *Context) (VarValue, error) {
+	function, ok := ctx.options.Functions[funcName]
+	if !ok {
+		if builtin, ok := builtinFunctions[funcName]; ok {
+			return builtin(args)
+		}
+		return "", &FunctionNotFoundError{FunctionName: funcName}
+	}
+	value := function(args...)
+	// A slow function can't be interrupted, but the render stops as soon as it returns
+	if err := ctx.session.Err(); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// rangeFunction implements range(start, end[, step]): the integers from start to end, both included.
+// The step defaults to 1, or -1 when end is lower than start.
+func rangeFunction(args []any) (VarValue, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, fmt.Errorf("range expects 2 or 3 arguments, got %d", len(args))
+	}
+	bounds := make([]int, len(args))
+	for i, arg := range args {
+		number, ok := toNumber(arg)
+		if !ok || number != math.Trunc(number) {
+			return nil, fmt.Errorf("range expects integer arguments, got %v", arg)
+		}
+		bounds[i] = int(number)
+	}
+	start, end := bounds[0], bounds[1]
+	step := 1
+	if end < start {
+		step = -1
+	}
+	if len(bounds) == 3 {
+		step = bounds[2]
+	}
+	if step == 0 {
+		return nil, errors.New("range step cannot be 0")
+	}
+	return iter.Seq[int](func(yield func(int) bool) {
+		for i := start; (step > 0 && i <= end) || (step < 0 && i >= end); i += step {
+			if !yield(i) {
+				return
+			}
+		}
+	}), nil
+}
+
+// isTruthy tells whether a value is considered true by IF, `!`, `&&`, `||` and `?:`:
+// only nil, false and "" are false
+func isTruthy(v VarValue) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case string:
+		return val != ""
+	}
+	// like in IF commands before expressions, any other value is true, even 0 or an empty list
+	return true
+}
+
+func valuesEqual(left, right VarValue) bool {
+	leftNum, leftIsNum := toNumber(left)
+	rightNum, rightIsNum := toNumber(right)
+	if leftIsNum && rightIsNum {
+		return leftNum == rightNum
+	}
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	if reflect.TypeOf(left).Comparable() && reflect.TypeOf(right).Comparable() {
+		return left == right
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// compareValues compares numbers (or numeric strings) numerically, and strings lexically
+func compareValues(left, right VarValue) (int, error) {
+	leftNum, leftIsNum := toNumber(left)
+	rightNum, rightIsNum := toNumber(right)
+	if leftIsNum && rightIsNum {
+		switch {
+		case leftNum < rightNum:
+			return -1, nil
+		case leftNum > rightNum:
+			return 1, nil
+		default:
+			return 0, nil
+		}
+	}
+	leftStr, leftIsStr := left.(string)
+	rightStr, rightIsStr := right.(string)
+	if leftIsStr && rightIsStr {
+		return strings.Compare(leftStr, rightStr), nil
+	}
+	return 0, fmt.Errorf("Cannot compare %v and %v", left, right)
+}
+
+// numericValue converts a value of any numeric type to float64
+func numericValue(v VarValue) (float64, bool) {
+	reflected := reflect.ValueOf(v)
+	switch reflected.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(reflected.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(reflected.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return reflected.Float(), true
+	}
+	return 0, false
+}
+
+// toInteger converts a value of any integer type to int64
+func toInteger(v VarValue) (int64, bool) {
+	reflected := reflect.ValueOf(v)
+	switch reflected.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflected.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(reflected.Uint()), true
+	}
+	return 0, false
+}
+
+// decimalNumberRegex matches the strings compared as numbers: finite decimal numbers,
+// unlike "NaN", "Inf" or hexadecimal numbers accepted by strconv.ParseFloat
+var decimalNumberRegex = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// Helper function: Convert value to float64 for comparison
+func toNumber(v VarValue) (float64, bool) {
+	if str, ok := v.(string); ok {
+		if !decimalNumberRegex.MatchString(str) {
+			return 0, false
+		}
+		if f, err := strconv.ParseFloat(str, 64); err == nil {
+			return f, true
+		}
+		return 0, false
+	}
+	return numericValue(v)
+}
+
+func toString(v VarValue) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,820 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// With the ConvertHtml option, the content of HTML commands is converted to native Word content
+// instead of an altChunk, which only Microsoft Word renders.
+// Supported are paragraphs and other blocks, headings (with the heading styles of the template),
+// bold, italic, underlined, struck, superscript, subscript and code text, colors, alignment,
+// bullet and ordered lists, tables, hyperlinks, line breaks and images given as data URLs.
+// Other elements are converted as their content.
+
+// htmlElement is an element of a parsed HTML snippet, its children are *htmlElement or string
+type htmlElement struct {
+	tag      string
+	attrs    map[string]string
+	children []any
+}
+
+// htmlImpliedEnds are the elements closed by the start of an element, e.g. an open li by the next li.
+// An element is only closed if it is found in the open elements before one of its htmlScopes.
+var htmlImpliedEnds = map[string][]string{
+	"li":    {"li"},
+	"dt":    {"dt", "dd"},
+	"dd":    {"dt", "dd"},
+	"td":    {"td", "th"},
+	"th":    {"td", "th"},
+	"tr":    {"tr", "td", "th"},
+	"thead": {"thead", "tbody", "tfoot", "tr", "td", "th"},
+	"tbody": {"thead", "tbody", "tfoot", "tr", "td", "th"},
+	"tfoot": {"thead", "tbody", "tfoot", "tr", "td", "th"},
+}
+
+var htmlScopes = map[string][]string{
+	"li":    {"ul", "ol"},
+	"dt":    {"dl"},
+	"dd":    {"dl"},
+	"td":    {"tr", "table"},
+	"th":    {"tr", "table"},
+	"tr":    {"table"},
+	"thead": {"table"},
+	"tbody": {"table"},
+	"tfoot": {"table"},
+	"p":     {"table", "td", "th", "li", "dt", "dd", "ul", "ol"},
+}
+
+// htmlClosingP are the block elements closing an open p
+var htmlClosingP = []string{
+	"p", "div", "section", "article", "header", "footer", "main", "nav", "aside", "address", "figure",
+	"ul", "ol", "dl", "table", "pre", "blockquote", "hr", "h1", "h2", "h3", "h4", "h5", "h6",
+}
+
+// parseHtml parses an HTML snippet leniently: missing end tags are added,
+// and void elements (br, img...) and HTML entities are supported
+func parseHtml(html string) (*htmlElement, error) {
+	decoder := xml.NewDecoder(strings.NewReader("<html-snippet>" + html + "</html-snippet>"))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	var root *htmlElement
+	stack := []*htmlElement{}
+	// closeOpen closes the innermost (or outermost) open element with one of the tags,
+	// and the elements it contains, unless it is out of the scope of the given tag
+	closeOpen := func(tags []string, scopeOf string, outermost bool) {
+		closeAt := -1
+		for i := len(stack) - 1; i > 0 && !slices.Contains(htmlScopes[scopeOf], stack[i].tag); i-- {
+			if slices.Contains(tags, stack[i].tag) {
+				closeAt = i
+				if !outermost {
+					break
+				}
+			}
+		}
+		if closeAt != -1 {
+			stack = stack[:closeAt]
+		}
+	}
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			element := &htmlElement{tag: htmlTag(t.Name), attrs: map[string]string{}}
+			for _, attr := range t.Attr {
+				element.attrs[strings.ToLower(attr.Name.Local)] = attr.Value
+			}
+			if closed, ok := htmlImpliedEnds[element.tag]; ok {
+				closeOpen(closed, element.tag, true)
+			}
+			if slices.Contains(htmlClosingP, element.tag) {
+				closeOpen([]string{"p"}, "p", true)
+			}
+			if len(stack) == 0 {
+				root = element
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, element)
+			}
+			stack = append(stack, element)
+		case xml.EndElement:
+			// the decoder also ends the elements closed above, ignore these
+			closeOpen([]string{htmlTag(t.Name)}, htmlTag(t.Name), false)
+		case xml.CharData:
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, string(t))
+			}
+		}
+	}
+	return root, nil
+}
+
+func htmlTag(name xml.Name) string {
+	if name.Space != "" {
+		// e.g. the o:p elements of Office HTML
+		return strings.ToLower(name.Space + ":" + name.Local)
+	}
+	return strings.ToLower(name.Local)
+}
+
+// htmlRun holds the run properties of the converted text
+type htmlRun struct {
+	bold      bool
+	italic    bool
+	underline bool
+	strike    bool
+	vertAlign string
+	color     string
+	font      string
+	style     string // character style id
+}
+
+// htmlBlock holds the properties of the paragraphs of a converted block
+type htmlBlock struct {
+	style    string // paragraph style id
+	align    string
+	indent   int // left indentation in twips
+	border   bool
+	pre      bool
+	listItem *htmlListItem
+}
+
+type htmlListItem struct {
+	numId    int
+	level    int
+	numbered bool // the paragraph with the number or bullet of the item was created
+}
+
+type htmlConverter struct {
+	ctx       *Context
+	blocks    *[]Node      // converted paragraphs and tables
+	paragraph *NonTextNode // current paragraph, created by the first inline content of a block
+	block     htmlBlock
+	link      map[string]string // attributes of the current hyperlink
+	linkNode  *NonTextNode
+	lists     int  // number of enclosing lists
+	spaced    bool // the paragraph is empty, or ends with a space
+}
+
+// htmlToWordNodes converts HTML to Word paragraphs and tables
+func htmlToWordNodes(html string, ctx *Context) ([]Node, error) {
+	root, err := parseHtml(html)
+	if err != nil {
+		return nil, err
+	}
+	blocks := []Node{}
+	c := &htmlConverter{ctx: ctx, blocks: &blocks}
+	err = c.convertChildren(root, htmlRun{})
+	if err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+func (c *htmlConverter) convertChildren(element *htmlElement, run htmlRun) error {
+	for _, child := range element.children {
+		switch child := child.(type) {
+		case string:
+			c.text(child, run)
+		case *htmlElement:
+			err := c.convertElement(child, run)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *htmlConverter) convertElement(element *htmlElement, run htmlRun) error {
+	switch element.tag {
+	case "head", "title", "script", "style", "template":
+		return nil
+	case "br":
+		c.addRun(run, NewNonTextNode("w:br", nil, nil))
+		c.spaced = true
+		return nil
+	case "img":
+		return c.image(element, run)
+	case "hr":
+		c.endParagraph()
+		saved := c.block
+		c.block = htmlBlock{border: true}
+		c.currentParagraph()
+		c.endParagraph()
+		c.block = saved
+		return nil
+	case "a":
+		return c.hyperlink(element, run)
+	case "ul", "ol":
+		return c.list(element, run)
+	case "table":
+		return c.table(element, run)
+	case "b", "strong":
+		run.bold = true
+	case "i", "em", "cite", "dfn", "var":
+		run.italic = true
+	case "u", "ins":
+		run.underline = true
+	case "s", "strike", "del":
+		run.strike = true
+	case "sup":
+		run.vertAlign = "superscript"
+	case "sub":
+		run.vertAlign = "subscript"
+	case "code", "kbd", "samp", "tt", "pre":
+		run.font = "Courier New"
+	}
+	run = applyRunStyle(run, element.attrs["style"])
+
+	block, isBlock := c.blockOf(element)
+	if !isBlock {
+		return c.convertChildren(element, run)
+	}
+	if element.tag == "pre" {
+		// like browsers, ignore the newline ending a preformatted text
+		trimFinalNewline(element)
+	}
+	c.endParagraph()
+	saved := c.block
+	c.block = block
+	err := c.convertChildren(element, run)
+	c.endParagraph()
+	c.block = saved
+	return err
+}
+
+// blockOf returns the paragraph properties of a block element
+func (c *htmlConverter) blockOf(element *htmlElement) (htmlBlock, bool) {
+	block := c.block
+	switch element.tag {
+	case "p", "div", "section", "article", "header", "footer", "main", "nav", "aside",
+		"address", "figure", "figcaption", "dl", "dt", "center", "li":
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := element.tag[1:]
+		block.style = c.ctx.session.styles.IdByName("heading "+level, "Heading"+level)
+	case "blockquote", "dd":
+		block.indent += 720
+	case "pre":
+		block.pre = true
+	default:
+		return block, false
+	}
+	if element.tag == "center" {
+		block.align = "center"
+	}
+	if align := htmlAlign(cssDeclarations(element.attrs["style"])["text-align"]); align != "" {
+		block.align = align
+	} else if align := htmlAlign(element.attrs["align"]); align != "" {
+		block.align = align
+	}
+	return block, true
+}
+
+func trimFinalNewline(element *htmlElement) {
+	if len(element.children) == 0 {
+		return
+	}
+	last := len(element.children) - 1
+	switch child := element.children[last].(type) {
+	case string:
+		element.children[last] = strings.TrimSuffix(child, "\n")
+	case *htmlElement:
+		trimFinalNewline(child)
+	}
+}
+
+func (c *htmlConverter) text(text string, run htmlRun) {
+	if c.block.pre {
+		if c.paragraph == nil {
+			text = strings.TrimPrefix(text, "\n")
+		}
+		for i, line := range strings.Split(text, "\n") {
+			if i > 0 {
+				c.addRun(run, NewNonTextNode("w:br", nil, nil))
+			}
+			if line != "" {
+				c.addText(line, run)
+			}
+		}
+		return
+	}
+
+	words := strings.FieldsFunc(text, isHtmlSpace)
+	startsWithSpace := text != "" && isHtmlSpace(rune(text[0]))
+	endsWithSpace := text != "" && isHtmlSpace(rune(text[len(text)-1]))
+	if len(words) == 0 {
+		if startsWithSpace && c.paragraph != nil && !c.spaced {
+			c.addText(" ", run)
+		}
+		return
+	}
+	collapsed := strings.Join(words, " ")
+	if startsWithSpace && c.paragraph != nil && !c.spaced {
+		collapsed = " " + collapsed
+	}
+	if endsWithSpace {
+		collapsed += " "
+	}
+	c.addText(collapsed, run)
+}
+
+func isHtmlSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f'
+}
+
+func (c *htmlConverter) addText(text string, run htmlRun) {
+	c.addRun(run, NewNonTextNode(T_TAG, map[string]string{"xml:space": "preserve"}, []Node{escapedTextNode(text, c.ctx.options.LiteralXmlDelimiter)}))
+	c.spaced = strings.HasSuffix(text, " ")
+}
+
+// addRun adds a run to the current paragraph, or to the current hyperlink
+func (c *htmlConverter) addRun(run htmlRun, content ...Node) {
+	paragraph := c.currentParagraph()
+	var parent Node = paragraph
+	if c.link != nil {
+		if c.linkNode == nil || c.linkNode.Parent() != paragraph {
+			c.linkNode = NewNonTextNode("w:hyperlink", maps.Clone(c.link), nil)
+			AddChild(paragraph, c.linkNode)
+		}
+		parent = c.linkNode
+	}
+	AddChild(parent, NewNonTextNode(R_TAG, nil, append(runProperties(run), content...)))
+}
+
+func runProperties(run htmlRun) []Node {
+	node := NewNonTextNode
+	props := []Node{}
+	if run.style != "" {
+		props = append(props, node("w:rStyle", map[string]string{"w:val": run.style}, nil))
+	}
+	if run.font != "" {
+		props = append(props, node("w:rFonts", map[string]string{"w:ascii": run.font, "w:hAnsi": run.font, "w:cs": run.font}, nil))
+	}
+	if run.bold {
+		props = append(props, node("w:b", nil, nil))
+	}
+	if run.italic {
+		props = append(props, node("w:i", nil, nil))
+	}
+	if run.strike {
+		props = append(props, node("w:strike", nil, nil))
+	}
+	if run.color != "" {
+		props = append(props, node("w:color", map[string]string{"w:val": run.color}, nil))
+	}
+	if run.underline {
+		props = append(props, node("w:u", map[string]string{"w:val": "single"}, nil))
+	}
+	if run.vertAlign != "" {
+		props = append(props, node("w:vertAlign", map[string]string{"w:val": run.vertAlign}, nil))
+	}
+	if len(props) == 0 {
+		return nil
+	}
+	return []Node{node(RPR_TAG, nil, props)}
+}
+
+// currentParagraph returns the paragraph of the current block, creating it if needed
+func (c *htmlConverter) currentParagraph() *NonTextNode {
+	if c.paragraph != nil {
+		return c.paragraph
+	}
+	node := NewNonTextNode
+	props := []Node{}
+	if c.block.style != "" {
+		props = append(props, node("w:pStyle", map[string]string{"w:val": c.block.style}, nil))
+	}
+	indent := c.block.indent
+	if item := c.block.listItem; item != nil {
+		if !item.numbered {
+			props = append(props, node("w:numPr", nil, []Node{
+				node("w:ilvl", map[string]string{"w:val": strconv.Itoa(item.level)}, nil),
+				node("w:numId", map[string]string{"w:val": strconv.Itoa(item.numId)}, nil),
+			}))
+			item.numbered = true
+		} else {
+			// next paragraphs of the item are aligned with its text
+			indent += 720 * (item.level + 1)
+		}
+	}
+	if c.block.border {
+		props = append(props, node("w:pBdr", nil, []Node{
+			node("w:bottom", map[string]string{"w:val": "single", "w:sz": "6", "w:space": "1", "w:color": "auto"}, nil),
+		}))
+	}
+	if indent > 0 {
+		props = append(props, node("w:ind", map[string]string{"w:left": strconv.Itoa(indent)}, nil))
+	}
+	if c.block.align != "" {
+		props = append(props, node("w:jc", map[string]string{"w:val": c.block.align}, nil))
+	}
+	children := []Node{}
+	if len(props) > 0 {
+		children = append(children, node("w:pPr", nil, props))
+	}
+	c.paragraph = node(P_TAG, nil, children)
+	*c.blocks = append(*c.blocks, c.paragraph)
+	c.spaced = true
+	return c.paragraph
+}
+
+func (c *htmlConverter) endParagraph() {
+	c.paragraph = nil
+}
+
+func (c *htmlConverter) hyperlink(element *htmlElement, run htmlRun) error {
+	href := element.attrs["href"]
+	if href == "" {
+		return c.convertChildren(element, applyRunStyle(run, element.attrs["style"]))
+	}
+	saved := c.link
+	if anchor, isAnchor := strings.CutPrefix(href, "#"); isAnchor {
+		c.link = map[string]string{"w:anchor": anchor, "w:history": "1"}
+	} else {
+		c.link = map[string]string{"r:id": linkToContext(c.ctx, href), "w:history": "1"}
+	}
+	c.linkNode = nil
+	run.style = c.ctx.session.styles.IdByName("hyperlink", "")
+	if run.style == "" {
+		run.underline = true
+		run.color = "0563C1"
+	}
+	err := c.convertChildren(element, applyRunStyle(run, element.attrs["style"]))
+	c.link = saved
+	c.linkNode = nil
+	return err
+}
+
+func (c *htmlConverter) list(element *htmlElement, run htmlRun) error {
+	c.endParagraph()
+	numbering := Numbering{
+		Ordered: element.tag == "ol",
+		Level:   min(c.lists, 8),
+		Start:   1,
+	}
+	if start, err := strconv.Atoi(element.attrs["start"]); err == nil && numbering.Ordered {
+		numbering.Start = start
+	}
+	c.ctx.session.numId += 1
+	numbering.NumId = c.ctx.session.numId
+	c.ctx.numberings = append(c.ctx.numberings, numbering)
+
+	saved := c.block
+	c.lists += 1
+	defer func() {
+		c.block = saved
+		c.lists -= 1
+	}()
+	for _, child := range element.children {
+		item, ok := child.(*htmlElement)
+		if !ok {
+			continue
+		}
+		c.block = saved
+		if item.tag != "li" {
+			// e.g. a list nested directly in a list
+			err := c.convertElement(item, run)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		listItem := &htmlListItem{numId: numbering.NumId, level: numbering.Level}
+		c.block.listItem = listItem
+		c.block.style = c.ctx.session.styles.IdByName("list paragraph", "")
+		c.block.indent = 0
+		err := c.convertChildren(item, applyRunStyle(run, item.attrs["style"]))
+		if err != nil {
+			return err
+		}
+		if !listItem.numbered {
+			c.currentParagraph()
+		}
+		c.endParagraph()
+	}
+	return nil
+}
+
+func (c *htmlConverter) table(element *htmlElement, run htmlRun) error {
+	node := NewNonTextNode
+	c.endParagraph()
+
+	type htmlRow struct {
+		element *htmlElement
+		header  bool
+	}
+	rows := []htmlRow{}
+	for _, child := range element.children {
+		child, ok := child.(*htmlElement)
+		if !ok {
+			continue
+		}
+		switch child.tag {
+		case "tr":
+			rows = append(rows, htmlRow{child, false})
+		case "thead", "tbody", "tfoot":
+			for _, row := range child.children {
+				if row, ok := row.(*htmlElement); ok && row.tag == "tr" {
+					rows = append(rows, htmlRow{row, child.tag == "thead"})
+				}
+			}
+		case "caption":
+			err := c.convertElement(&htmlElement{tag: "p", attrs: child.attrs, children: child.children}, run)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	columns := 0
+	rowNodes := []Node{}
+	for _, row := range rows {
+		cells := []Node{}
+		if row.header {
+			cells = append(cells, node("w:trPr", nil, []Node{node("w:tblHeader", nil, nil)}))
+		}
+		width := 0
+		for _, child := range row.element.children {
+			cell, ok := child.(*htmlElement)
+			if !ok || (cell.tag != "td" && cell.tag != "th") {
+				continue
+			}
+			cellRun := run
+			if cell.tag == "th" {
+				cellRun.bold = true
+			}
+			cellBlocks := []Node{}
+			cellConverter := &htmlConverter{ctx: c.ctx, blocks: &cellBlocks, lists: c.lists}
+			cellConverter.block.align = htmlAlign(cssDeclarations(cell.attrs["style"])["text-align"])
+			if cellConverter.block.align == "" {
+				cellConverter.block.align = htmlAlign(cell.attrs["align"])
+			}
+			err := cellConverter.convertChildren(cell, applyRunStyle(cellRun, cell.attrs["style"]))
+			if err != nil {
+				return err
+			}
+			// a cell must end with a paragraph
+			if len(cellBlocks) == 0 || cellBlocks[len(cellBlocks)-1].(*NonTextNode).Tag != P_TAG {
+				cellBlocks = append(cellBlocks, node(P_TAG, nil, nil))
+			}
+
+			cellProps := []Node{node("w:tcW", map[string]string{"w:w": "0", "w:type": "auto"}, nil)}
+			span, err := strconv.Atoi(cell.attrs["colspan"])
+			if err != nil || span < 1 {
+				span = 1
+			}
+			if span > 1 {
+				cellProps = append(cellProps, node("w:gridSpan", map[string]string{"w:val": strconv.Itoa(span)}, nil))
+			}
+			width += span
+			cells = append(cells, node(TC_TAG, nil, append([]Node{node("w:tcPr", nil, cellProps)}, cellBlocks...)))
+		}
+		columns = max(columns, width)
+		rowNodes = append(rowNodes, node(TR_TAG, nil, cells))
+	}
+	if columns == 0 {
+		return nil
+	}
+
+	tableProps := []Node{}
+	if style := c.ctx.session.styles.IdByName("table grid", ""); style != "" {
+		tableProps = append(tableProps, node("w:tblStyle", map[string]string{"w:val": style}, nil))
+	}
+	tableProps = append(tableProps, node("w:tblW", map[string]string{"w:w": "5000", "w:type": "pct"}, nil))
+	borders := []Node{}
+	for _, side := range []string{"w:top", "w:left", "w:bottom", "w:right", "w:insideH", "w:insideV"} {
+		borders = append(borders, node(side, map[string]string{"w:val": "single", "w:sz": "4", "w:space": "0", "w:color": "auto"}, nil))
+	}
+	tableProps = append(tableProps, node("w:tblBorders", nil, borders))
+
+	grid := []Node{}
+	for range columns {
+		grid = append(grid, node("w:gridCol", map[string]string{"w:w": strconv.Itoa(pageContentTwips(c.ctx) / columns)}, nil))
+	}
+	table := node(TBL_TAG, nil, append([]Node{node("w:tblPr", nil, tableProps), node("w:tblGrid", nil, grid)}, rowNodes...))
+	*c.blocks = append(*c.blocks, table)
+	return nil
+}
+
+// image adds an image given as a data URL, or its alternative text if it can't be inserted
+func (c *htmlConverter) image(element *htmlElement, run htmlRun) error {
+	imagePars, err := htmlImagePars(element)
+	if err != nil {
+		slog.Debug("Image not converted", "error", err)
+		c.text(element.attrs["alt"], run)
+		return nil
+	}
+	drawing, err := imageDrawing(c.ctx, imagePars)
+	if err != nil {
+		return err
+	}
+	c.addRun(run, drawing)
+	c.spaced = false
+	return nil
+}
+
+var htmlImageExtensions = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/jpg":     ".jpg",
+	"image/gif":     ".gif",
+	"image/svg+xml": ".svg",
+}
+
+func htmlImagePars(element *htmlElement) (*ImagePars, error) {
+	src := element.attrs["src"]
+	dataUrl, isDataUrl := strings.CutPrefix(src, "data:")
+	meta, payload, hasPayload := strings.Cut(dataUrl, ",")
+	if !isDataUrl || !hasPayload {
+		return nil, fmt.Errorf("unsupported image source %q, only data URLs can be converted", src)
+	}
+	extension, ok := htmlImageExtensions[strings.ToLower(strings.TrimSpace(strings.Split(meta, ";")[0]))]
+	if !ok {
+		return nil, fmt.Errorf("unsupported image type %q", meta)
+	}
+	var data []byte
+	var err error
+	if strings.HasSuffix(strings.ToLower(meta), ";base64") {
+		data, err = base64.StdEncoding.DecodeString(strings.Join(strings.FieldsFunc(payload, isHtmlSpace), ""))
+	} else {
+		var unescaped string
+		unescaped, err = url.PathUnescape(payload)
+		data = []byte(unescaped)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	css := cssDeclarations(element.attrs["style"])
+	width := htmlPixels(css["width"], element.attrs["width"])
+	height := htmlPixels(css["height"], element.attrs["height"])
+	if width == 0 || height == 0 {
+		if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil && config.Width > 0 && config.Height > 0 {
+			switch {
+			case width == 0 && height == 0:
+				width, height = float32(config.Width), float32(config.Height)
+			case width == 0:
+				width = height * float32(config.Width) / float32(config.Height)
+			default:
+				height = width * float32(config.Height) / float32(config.Width)
+			}
+		}
+	}
+	if width == 0 || height == 0 {
+		return nil, errors.New("unknown image size, width and height attributes are needed")
+	}
+
+	const cmPerPixel = 2.54 / 96
+	return &ImagePars{
+		Extension: extension,
+		Data:      data,
+		Width:     width * cmPerPixel,
+		Height:    height * cmPerPixel,
+		Alt:       element.attrs["alt"],
+	}, nil
+}
+
+// htmlPixels returns the first length in pixels of the given CSS or attribute values, or 0
+func htmlPixels(values ...string) float32 {
+	for _, value := range values {
+		number, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "px"), 32)
+		if err == nil && number > 0 {
+			return float32(number)
+		}
+	}
+	return 0
+}
+
+func htmlAlign(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "left", "start":
+		return "left"
+	case "center":
+		return "center"
+	case "right", "end":
+		return "right"
+	case "justify":
+		return "both"
+	}
+	return ""
+}
+
+// cssDeclarations parses the declarations of a style attribute
+func cssDeclarations(style string) map[string]string {
+	declarations := map[string]string{}
+	for _, declaration := range strings.Split(style, ";") {
+		property, value, ok := strings.Cut(declaration, ":")
+		if ok {
+			declarations[strings.ToLower(strings.TrimSpace(property))] = strings.TrimSpace(value)
+		}
+	}
+	return declarations
+}
+
+func applyRunStyle(run htmlRun, style string) htmlRun {
+	if style == "" {
+		return run
+	}
+	for property, value := range cssDeclarations(style) {
+		value = strings.ToLower(value)
+		switch property {
+		case "font-weight":
+			weight, err := strconv.Atoi(value)
+			run.bold = value == "bold" || value == "bolder" || (err == nil && weight >= 600)
+		case "font-style":
+			run.italic = value == "italic" || value == "oblique"
+		case "text-decoration", "text-decoration-line":
+			run.underline = strings.Contains(value, "underline")
+			run.strike = strings.Contains(value, "line-through")
+		case "color":
+			if color := cssColor(value); color != "" {
+				run.color = color
+			}
+		case "font-family":
+			family := strings.Trim(strings.TrimSpace(strings.Split(value, ",")[0]), `"'`)
+			if family != "" {
+				run.font = family
+			}
+		case "vertical-align":
+			switch value {
+			case "super":
+				run.vertAlign = "superscript"
+			case "sub":
+				run.vertAlign = "subscript"
+			}
+		}
+	}
+	return run
+}
+
+var cssColorNames = map[string]string{
+	"black":  "000000",
+	"white":  "FFFFFF",
+	"red":    "FF0000",
+	"green":  "008000",
+	"blue":   "0000FF",
+	"yellow": "FFFF00",
+	"orange": "FFA500",
+	"purple": "800080",
+	"gray":   "808080",
+	"grey":   "808080",
+}
+
+// cssColor returns the hexadecimal RRGGBB value of a CSS color, or an empty string if not supported
+func cssColor(value string) string {
+	if named, ok := cssColorNames[value]; ok {
+		return named
+	}
+	if hex, ok := strings.CutPrefix(value, "#"); ok {
+		if _, err := strconv.ParseUint(hex, 16, 32); err != nil {
+			return ""
+		}
+		switch len(hex) {
+		case 3:
+			return strings.ToUpper(string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]}))
+		case 6:
+			return strings.ToUpper(hex)
+		}
+		return ""
+	}
+	if args, ok := strings.CutPrefix(value, "rgb("); ok {
+		parts := strings.Split(strings.TrimSuffix(args, ")"), ",")
+		if len(parts) != 3 {
+			return ""
+		}
+		color := ""
+		for _, part := range parts {
+			component, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || component < 0 || component > 255 {
+				return ""
+			}
+			color += fmt.Sprintf("%02X", component)
+		}
+		return color
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// ImageAnchor positions a floating image, the text wrapping around it
+type ImageAnchor struct {
+	RelativeTo      string  // optional, "page", "margin" or "paragraph" (default, the image moves with the text)
+	X               float32 // optional, horizontal offset in cm
+	Y               float32 // optional, vertical offset in cm
+	HorizontalAlign string  // optional, "left", "center" or "right", instead of X
+	VerticalAlign   string  // optional, "top", "center" or "bottom", instead of Y
+	Wrap            string  // optional, "square" (default), "tight", "topAndBottom", "behindText" or "inFrontOfText"
+	Distance        float32 // optional, distance from the text in cm, 0.32 cm on the sides by default
+	ZOrder          int     // optional, images with a higher z-order are in front of the others
+}
+
+// anchorRelativeTo gives the horizontal and vertical wp:anchor positioning bases of ImageAnchor.RelativeTo
+var anchorRelativeTo = map[string][2]string{
+	"":          {"column", "paragraph"},
+	"paragraph": {"column", "paragraph"},
+	"margin":    {"margin", "margin"},
+	"page":      {"page", "page"},
+}
+
+var (
+	anchorHorizontalAligns = []string{"", "left", "center", "right"}
+	anchorVerticalAligns   = []string{"", "top", "center", "bottom"}
+	anchorWraps            = []string{"", "square", "tight", "topAndBottom", "behindText", "inFrontOfText"}
+)
+
+// ANCHOR_BASE_RELATIVE_HEIGHT is the relativeHeight of the images with a z-order of 0, as used by Word
+const ANCHOR_BASE_RELATIVE_HEIGHT = 251658240
+
+// validateImageAnchor checks the positioning values of a floating image
+func validateImageAnchor(anchor *ImageAnchor) error {
+	if _, ok := anchorRelativeTo[anchor.RelativeTo]; !ok {
+		return fmt.Errorf("Invalid anchor RelativeTo %q, one of page, margin or paragraph is expected", anchor.RelativeTo)
+	}
+	if !slices.Contains(anchorHorizontalAligns, anchor.HorizontalAlign) {
+		return fmt.Errorf("Invalid anchor HorizontalAlign %q, one of %v is expected", anchor.HorizontalAlign, anchorHorizontalAligns[1:])
+	}
+	if !slices.Contains(anchorVerticalAligns, anchor.VerticalAlign) {
+		return fmt.Errorf("Invalid anchor VerticalAlign %q, one of %v is expected", anchor.VerticalAlign, anchorVerticalAligns[1:])
+	}
+	if !slices.Contains(anchorWraps, anchor.Wrap) {
+		return fmt.Errorf("Invalid anchor Wrap %q, one of %v is expected", anchor.Wrap, anchorWraps[1:])
+	}
+	if anchor.ZOrder < 0 {
+		return fmt.Errorf("Invalid anchor ZOrder %d, it can't be negative", anchor.ZOrder)
+	}
+	return nil
+}
+
+// imageAnchor returns the wp:anchor of a floating image, the extent being followed by the given children
+func imageAnchor(anchor *ImageAnchor, extent *NonTextNode, children []Node) *NonTextNode {
+	node := NewNonTextNode
+	emu := func(cm float32) string { return fmt.Sprint(int(cm * 360e3)) }
+
+	distances := map[string]string{"distT": "0", "distB": "0", "distL": "114300", "distR": "114300"}
+	if anchor.Distance > 0 {
+		for side := range distances {
+			distances[side] = emu(anchor.Distance)
+		}
+	}
+	behindDoc := "0"
+	if anchor.Wrap == "behindText" {
+		behindDoc = "1"
+	}
+	attrs := map[string]string{
+		"simplePos":      "0",
+		"relativeHeight": strconv.Itoa(ANCHOR_BASE_RELATIVE_HEIGHT + anchor.ZOrder),
+		"behindDoc":      behindDoc,
+		"locked":         "0",
+		"layoutInCell":   "1",
+		"allowOverlap":   "1",
+	}
+	for side, distance := range distances {
+		attrs[side] = distance
+	}
+
+	position := func(tag string, relativeFrom string, align string, offset float32) *NonTextNode {
+		if align != "" {
+			return node(tag, map[string]string{"relativeFrom": relativeFrom}, []Node{node("wp:align", nil, []Node{NewTextNode(align)})})
+		}
+		return node(tag, map[string]string{"relativeFrom": relativeFrom}, []Node{node("wp:posOffset", nil, []Node{NewTextNode(emu(offset))})})
+	}
+	relativeTo := anchorRelativeTo[anchor.RelativeTo]
+
+	var wrap *NonTextNode
+	switch anchor.Wrap {
+	case "tight":
+		// the wrap polygon of a rectangular image, in 21600ths of its size
+		wrap = node("wp:wrapTight", map[string]string{"wrapText": "bothSides"}, []Node{
+			node("wp:wrapPolygon", map[string]string{"edited": "0"}, []Node{
+				node("wp:start", map[string]string{"x": "0", "y": "0"}, nil),
+				node("wp:lineTo", map[string]string{"x": "0", "y": "21600"}, nil),
+				node("wp:lineTo", map[string]string{"x": "21600", "y": "21600"}, nil),
+				node("wp:lineTo", map[string]string{"x": "21600", "y": "0"}, nil),
+				node("wp:lineTo", map[string]string{"x": "0", "y": "0"}, nil),
+			}),
+		})
+	case "topAndBottom":
+		wrap = node("wp:wrapTopAndBottom", nil, nil)
+	case "behindText", "inFrontOfText":
+		wrap = node("wp:wrapNone", nil, nil)
+	default:
+		wrap = node("wp:wrapSquare", map[string]string{"wrapText": "bothSides"}, nil)
+	}
+
+	return node("wp:anchor", attrs, append([]Node{
+		node("wp:simplePos", map[string]string{"x": "0", "y": "0"}, nil),
+		position("wp:positionH", relativeTo[0], anchor.HorizontalAlign, anchor.X),
+		position("wp:positionV", relativeTo[1], anchor.VerticalAlign, anchor.Y),
+		extent,
+		node("wp:effectExtent", map[string]string{"l": "0", "t": "0", "r": "0", "b": "0"}, nil),
+		wrap,
+	}, children...))
+}
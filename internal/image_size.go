@@ -0,0 +1,222 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DEFAULT_IMAGE_DPI is the resolution of the images which don't give theirs
+const DEFAULT_IMAGE_DPI = 96
+
+// imageDisplaySize returns the size of an image on the page, in cm. A missing width or height is
+// computed from the natural size of the image, keeping its aspect ratio. The size is then reduced
+// to fit in MaxWidth and MaxHeight, and in the width of the page content when the width isn't given.
+func imageDisplaySize(ctx *Context, pars *ImagePars) (float32, float32, error) {
+	width, height := pars.Width, pars.Height
+	if width <= 0 || height <= 0 {
+		naturalWidth, naturalHeight, err := imageNaturalSize(getImageData(pars))
+		if err != nil {
+			return 0, 0, fmt.Errorf("unknown image size, Width and Height need to be provided: %w", err)
+		}
+		switch {
+		case width <= 0 && height <= 0:
+			width, height = naturalWidth, naturalHeight
+		case width <= 0:
+			width = height * naturalWidth / naturalHeight
+		default:
+			height = width * naturalHeight / naturalWidth
+		}
+	}
+
+	maxWidth := pars.MaxWidth
+	if maxWidth <= 0 && pars.Width <= 0 {
+		maxWidth = ctx.session.pageContentWidth
+	}
+	scale := float32(1)
+	if maxWidth > 0 && width > maxWidth {
+		scale = maxWidth / width
+	}
+	if pars.MaxHeight > 0 && height*scale > pars.MaxHeight {
+		scale = pars.MaxHeight / height
+	}
+	return width * scale, height * scale, nil
+}
+
+// imageAspectRatio returns the width / height ratio of an image: the one of Width and Height if both are
+// given, else the one of its natural size
+func imageAspectRatio(pars *ImagePars) (float32, bool) {
+	if pars.Width > 0 && pars.Height > 0 {
+		return pars.Width / pars.Height, true
+	}
+	width, height, err := imageNaturalSize(getImageData(pars))
+	if err != nil {
+		return 0, false
+	}
+	return width / height, true
+}
+
+// imageNaturalSize returns the size of an image in cm, from its size in pixels and its resolution
+func imageNaturalSize(img *Image) (float32, float32, error) {
+	if img.Extension == ".svg" {
+		return svgSize(img.Data)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(img.Data))
+	if err != nil {
+		return 0, 0, err
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return 0, 0, errors.New("empty image")
+	}
+	dpiX, dpiY := imageDpi(img.Data)
+	return float32(float64(config.Width) / dpiX * 2.54), float32(float64(config.Height) / dpiY * 2.54), nil
+}
+
+// imageDpi returns the resolution of a PNG (pHYs chunk) or JPEG (JFIF header) image,
+// or DEFAULT_IMAGE_DPI
+func imageDpi(data []byte) (float64, float64) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		for pos := 8; pos+12 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[pos:]))
+			chunkType := string(data[pos+4 : pos+8])
+			if chunkType == "IDAT" || pos+12+length > len(data) {
+				break
+			}
+			// pixels per unit on each axis, and the unit: 1 for meters, 0 when only giving the aspect ratio
+			if chunkType == "pHYs" && length == 9 && data[pos+16] == 1 {
+				x := float64(binary.BigEndian.Uint32(data[pos+8:])) * 0.0254
+				y := float64(binary.BigEndian.Uint32(data[pos+12:])) * 0.0254
+				if x > 0 && y > 0 {
+					return x, y
+				}
+			}
+			pos += 12 + length
+		}
+
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+			marker := data[pos+1]
+			length := int(binary.BigEndian.Uint16(data[pos+2:]))
+			if marker == 0xDA || pos+2+length > len(data) {
+				break
+			}
+			// the density unit is 1 for dots per inch, 2 for dots per cm, 0 when only giving the aspect ratio
+			segment := data[pos+4 : pos+2+length]
+			if marker == 0xE0 && len(segment) >= 12 && bytes.HasPrefix(segment, []byte("JFIF\x00")) {
+				unit := segment[7]
+				x := float64(binary.BigEndian.Uint16(segment[8:]))
+				y := float64(binary.BigEndian.Uint16(segment[10:]))
+				if unit == 2 {
+					x, y = x*2.54, y*2.54
+				}
+				if unit != 0 && x > 0 && y > 0 {
+					return x, y
+				}
+			}
+			pos += 2 + length
+		}
+	}
+	return DEFAULT_IMAGE_DPI, DEFAULT_IMAGE_DPI
+}
+
+// svgSize returns the size of an SVG image in cm, from the width and height of its root element,
+// completed by its viewBox
+func svgSize(data []byte) (float32, float32, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return 0, 0, errors.New("no svg element")
+		} else if err != nil {
+			return 0, 0, err
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		attrs := map[string]string{}
+		for _, attr := range element.Attr {
+			attrs[attr.Name.Local] = attr.Value
+		}
+		width, hasWidth := svgLength(attrs["width"])
+		height, hasHeight := svgLength(attrs["height"])
+		var viewWidth, viewHeight float64
+		if viewBox := strings.FieldsFunc(attrs["viewBox"], func(r rune) bool { return r == ',' || r == ' ' }); len(viewBox) == 4 {
+			viewWidth, _ = strconv.ParseFloat(viewBox[2], 64)
+			viewHeight, _ = strconv.ParseFloat(viewBox[3], 64)
+		}
+		hasViewBox := viewWidth > 0 && viewHeight > 0
+		switch {
+		case hasWidth && hasHeight:
+		case hasWidth && hasViewBox:
+			height = width * viewHeight / viewWidth
+		case hasHeight && hasViewBox:
+			width = height * viewWidth / viewHeight
+		case hasViewBox:
+			// user units are pixels
+			width, height = viewWidth/DEFAULT_IMAGE_DPI*2.54, viewHeight/DEFAULT_IMAGE_DPI*2.54
+		default:
+			return 0, 0, errors.New("no width, height or viewBox on the svg element")
+		}
+		return float32(width), float32(height), nil
+	}
+}
+
+// svgLengthUnits are the sizes of the absolute SVG length units, in cm
+var svgLengthUnits = map[string]float64{
+	"":   2.54 / DEFAULT_IMAGE_DPI,
+	"px": 2.54 / DEFAULT_IMAGE_DPI,
+	"pt": 2.54 / 72,
+	"pc": 2.54 / 6,
+	"in": 2.54,
+	"cm": 1,
+	"mm": 0.1,
+}
+
+// svgLength converts an absolute SVG length to cm
+func svgLength(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	number := strings.TrimRight(value, "abcdefghijklmnopqrstuvwxyz%")
+	unit, ok := svgLengthUnits[value[len(number):]]
+	if !ok {
+		return 0, false
+	}
+	length, err := strconv.ParseFloat(number, 64)
+	if err != nil || length <= 0 {
+		return 0, false
+	}
+	return length * unit, true
+}
+
+// PageContentWidth returns the width between the margins of the last section of a document, in cm, or 0
+func PageContentWidth(document Node) float32 {
+	sections := descendants(document, "w:sectPr")
+	if len(sections) == 0 {
+		return 0
+	}
+	section := sections[len(sections)-1]
+	size := firstChildElement(section, "w:pgSz")
+	margins := firstChildElement(section, "w:pgMar")
+	if size == nil {
+		return 0
+	}
+	twips := func(node *NonTextNode, attr string) float64 {
+		if node == nil {
+			return 0
+		}
+		value, _ := strconv.ParseFloat(node.Attrs[attr], 64)
+		return value
+	}
+	width := twips(size, "w:w") - twips(margins, "w:left") - twips(margins, "w:right") - twips(margins, "w:gutter")
+	if width <= 0 {
+		return 0
+	}
+	return float32(width / 1440 * 2.54)
+}
@@ -0,0 +1,358 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// A FOR command can filter, group, sort and limit its items with SQL-like clauses,
+// each used at most once, and applied in this order whatever their order in the command:
+//
+//	FOR p IN people WHERE $p.active ORDER BY $p.lastname DESC, $p.firstname LIMIT 10
+//	FOR g IN orders WHERE $g.total > 0 GROUP BY $g.customer ORDER BY $g.key
+//
+// The clauses are expressions evaluated for each item, with the loop variable set.
+// After GROUP BY, the items are the groups (see Group).
+
+type loopClauses struct {
+	source  string // expression of the items to loop over
+	where   string
+	groupBy string
+	orderBy []orderByClause
+	limit   string
+}
+
+type orderByClause struct {
+	expression string
+	desc       bool
+}
+
+func (c *ParseCache) clauses(text string) (*loopClauses, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clauses, ok := c.loopClauses[text]
+	return clauses, ok
+}
+
+func (c *ParseCache) storeClauses(text string, clauses *loopClauses) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.loopClauses) < maxParseCacheEntries {
+		c.loopClauses[text] = clauses
+	}
+}
+
+// parseLoopClauses splits the expression following IN into the items expression and its clauses.
+// Clause keywords are only recognized after a complete operand, outside parentheses and strings,
+// so that data named e.g. `limit` can still be used.
+func parseLoopClauses(text string, cache *ParseCache) (*loopClauses, error) {
+	if cached, ok := cache.clauses(text); ok {
+		return cached, nil
+	}
+	tokens, err := tokenizeExpression(text)
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(text)
+
+	type clauseStart struct {
+		keyword string
+		start   int // position of the keyword
+		end     int // position of the clause expression
+	}
+	clauses := []clauseStart{}
+	depth := 0
+	afterKeyword := false
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.kind == tokenOperator && tok.text == "(" {
+			depth++
+		} else if tok.kind == tokenOperator && tok.text == ")" {
+			depth--
+		}
+		isKeywordPosition := depth == 0 && tok.kind == tokenPath && i > 0 && !afterKeyword && endsOperand(tokens[i-1])
+		afterKeyword = false
+		if !isKeywordPosition {
+			continue
+		}
+		keyword := strings.ToUpper(tok.text)
+		switch keyword {
+		case "WHERE", "LIMIT":
+			clauses = append(clauses, clauseStart{keyword, tok.pos, tok.pos + len([]rune(tok.text))})
+			afterKeyword = true
+		case "ORDER", "GROUP":
+			next := tokens[i+1]
+			if next.kind == tokenPath && strings.ToUpper(next.text) == "BY" {
+				clauses = append(clauses, clauseStart{keyword + " BY", tok.pos, next.pos + len([]rune(next.text))})
+				afterKeyword = true
+				i++
+			}
+		}
+	}
+
+	result := &loopClauses{source: text}
+	if len(clauses) > 0 {
+		result.source = string(runes[:clauses[0].start])
+	}
+	seen := map[string]bool{}
+	for i, clause := range clauses {
+		if seen[clause.keyword] {
+			return nil, NewInvalidCommandError("Duplicate "+clause.keyword+" clause", text)
+		}
+		seen[clause.keyword] = true
+		end := len(runes)
+		if i+1 < len(clauses) {
+			end = clauses[i+1].start
+		}
+		expression := strings.TrimSpace(string(runes[clause.end:end]))
+		if expression == "" {
+			return nil, NewInvalidCommandError("Empty "+clause.keyword+" clause", text)
+		}
+		switch clause.keyword {
+		case "WHERE":
+			result.where = expression
+		case "GROUP BY":
+			result.groupBy = expression
+		case "LIMIT":
+			result.limit = expression
+		case "ORDER BY":
+			result.orderBy, err = parseOrderBy(expression)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	result.source = strings.TrimSpace(result.source)
+
+	cache.storeClauses(text, result)
+	return result, nil
+}
+
+// parseOrderBy splits an ORDER BY clause into its comma separated expressions,
+// each one optionally followed by ASC or DESC
+func parseOrderBy(text string) ([]orderByClause, error) {
+	tokens, err := tokenizeExpression(text)
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(text)
+	orderBy := []orderByClause{}
+	depth := 0
+	start := 0
+	for i, tok := range tokens {
+		if tok.kind == tokenOperator && tok.text == "(" {
+			depth++
+		} else if tok.kind == tokenOperator && tok.text == ")" {
+			depth--
+		}
+		if tok.kind != tokenEOF && (depth != 0 || tok.kind != tokenOperator || tok.text != ",") {
+			continue
+		}
+		clause := orderByClause{expression: strings.TrimSpace(string(runes[start:tok.pos]))}
+		if i > 1 && tokens[i-1].kind == tokenPath && endsOperand(tokens[i-2]) {
+			switch strings.ToUpper(tokens[i-1].text) {
+			case "DESC":
+				clause.desc = true
+				clause.expression = strings.TrimSpace(string(runes[start:tokens[i-1].pos]))
+			case "ASC":
+				clause.expression = strings.TrimSpace(string(runes[start:tokens[i-1].pos]))
+			}
+		}
+		if clause.expression == "" {
+			return nil, NewInvalidCommandError("Empty ORDER BY expression", text)
+		}
+		orderBy = append(orderBy, clause)
+		start = tok.pos + 1
+	}
+	return orderBy, nil
+}
+
+// endsOperand tells whether a token can be the last one of an operand
+func endsOperand(tok exprToken) bool {
+	switch tok.kind {
+	case tokenNumber, tokenString, tokenPath:
+		return true
+	case tokenOperator:
+		return tok.text == ")"
+	}
+	return false
+}
+
+// applyLoopClauses filters, groups, sorts and limits the items of a FOR loop
+func applyLoopClauses(items []VarValue, clauses *loopClauses, varName string, ctx *Context, data any) ([]VarValue, error) {
+	if clauses.where == "" && clauses.groupBy == "" && len(clauses.orderBy) == 0 && clauses.limit == "" {
+		return items, nil
+	}
+
+	// the clauses see the current item as the loop variable, restore its previous value afterwards
+	varKey := "$" + varName
+	previous, hadPrevious := ctx.vars[varKey]
+	defer func() {
+		if hadPrevious {
+			ctx.vars[varKey] = previous
+		} else {
+			delete(ctx.vars, varKey)
+		}
+	}()
+	evalForItem := func(expression string, item VarValue) (VarValue, error) {
+		ctx.vars[varKey] = item
+		return runAndGetValue(expression, ctx, data)
+	}
+
+	if clauses.where != "" {
+		filtered := []VarValue{}
+		for _, item := range items {
+			keep, err := evalForItem(clauses.where, item)
+			if err != nil {
+				return nil, fmt.Errorf("WHERE %s: %w", clauses.where, err)
+			}
+			if isTruthy(keep) {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	if clauses.groupBy != "" {
+		groups, err := groupItems(items, func(item VarValue) (VarValue, error) {
+			return evalForItem(clauses.groupBy, item)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("GROUP BY %s: %w", clauses.groupBy, err)
+		}
+		items = make([]VarValue, len(groups))
+		for i, group := range groups {
+			items[i] = group
+		}
+	}
+
+	if len(clauses.orderBy) > 0 {
+		type sortedItem struct {
+			item VarValue
+			keys []VarValue
+		}
+		sorted := make([]sortedItem, len(items))
+		for i, item := range items {
+			sorted[i] = sortedItem{item: item, keys: make([]VarValue, len(clauses.orderBy))}
+			for j, orderBy := range clauses.orderBy {
+				key, err := evalForItem(orderBy.expression, item)
+				if err != nil {
+					return nil, fmt.Errorf("ORDER BY %s: %w", orderBy.expression, err)
+				}
+				sorted[i].keys[j] = key
+			}
+		}
+		slices.SortStableFunc(sorted, func(a, b sortedItem) int {
+			for j, orderBy := range clauses.orderBy {
+				result := compareSortKeys(a.keys[j], b.keys[j])
+				if orderBy.desc {
+					result = -result
+				}
+				if result != 0 {
+					return result
+				}
+			}
+			return 0
+		})
+		for i := range sorted {
+			items[i] = sorted[i].item
+		}
+	}
+
+	if clauses.limit != "" {
+		value, err := runAndGetValue(clauses.limit, ctx, data)
+		if err != nil {
+			return nil, fmt.Errorf("LIMIT %s: %w", clauses.limit, err)
+		}
+		number, ok := toNumber(value)
+		if !ok || number < 0 || number != float64(int(number)) {
+			return nil, NewInvalidCommandError(fmt.Sprintf("LIMIT must be a non-negative integer, got %v", value), clauses.limit)
+		}
+		if limit := int(number); limit < len(items) {
+			items = items[:limit]
+		}
+	}
+	return items, nil
+}
+
+// compareSortKeys orders the ORDER BY values: nil first, then numbers and strings
+// with compareValues, and other values by their text representation
+func compareSortKeys(a, b VarValue) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	if result, err := compareValues(a, b); err == nil {
+		return result
+	}
+	return strings.Compare(toString(a), toString(b))
+}
+
+// groupItems groups items by key, in the order of the first item of each group
+func groupItems(items []VarValue, keyOf func(item VarValue) (VarValue, error)) ([]Group, error) {
+	groups := []Group{}
+	groupIdx := map[any]int{}
+	for _, item := range items {
+		key, err := keyOf(item)
+		if err != nil {
+			return nil, err
+		}
+		mapKey, err := groupKey(key)
+		if err != nil {
+			return nil, err
+		}
+		idx, ok := groupIdx[mapKey]
+		if !ok {
+			idx = len(groups)
+			groupIdx[mapKey] = idx
+			groups = append(groups, Group{Key: key})
+		}
+		groups[idx].Items = append(groups[idx].Items, item)
+		groups[idx].Count++
+	}
+	return groups, nil
+}
+
+// groupKey returns the map key of a group: numbers of any type are grouped by their value,
+// and the values which cannot be compared, like slices or maps, are rejected
+func groupKey(key VarValue) (any, error) {
+	if number, ok := numericValue(key); ok {
+		return number, nil
+	}
+	if key != nil && !reflect.ValueOf(key).Comparable() {
+		return nil, fmt.Errorf("cannot group by a %T value", key)
+	}
+	return key, nil
+}
+
+// groupByFunction implements groupBy(items, 'path'): the items of a slice or an array,
+// grouped by the value at path in each of them
+func groupByFunction(args []any) (VarValue, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("groupBy expects 2 arguments, got %d", len(args))
+	}
+	path, ok := args[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("groupBy expects a path as second argument, got %v", args[1])
+	}
+	reflected := reflect.ValueOf(args[0])
+	if reflected.Kind() != reflect.Slice && reflected.Kind() != reflect.Array {
+		return nil, fmt.Errorf("groupBy can only group a slice or an array, got %v", reflected.Kind())
+	}
+	items := make([]VarValue, reflected.Len())
+	for i := range items {
+		items[i] = reflected.Index(i).Interface()
+	}
+	return groupItems(items, func(item VarValue) (VarValue, error) {
+		key, _ := getValueFrom(path, item)
+		return key, nil
+	})
+}
@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// markdownToHtml renders Markdown (with tables, fenced code, strikethrough, autolinks
+// and ordered lists starting at any number) to HTML
+func markdownToHtml(text string) string {
+	// a parser can't be reused between documents
+	p := parser.NewWithExtensions(parser.CommonExtensions | parser.OrderedListStart)
+	renderer := html.NewRenderer(html.RendererOptions{})
+	return string(markdown.ToHTML([]byte(text), p, renderer))
+}
+
+// processMarkdown converts Markdown to native Word paragraphs, replacing the paragraph
+// of the MARKDOWN command
+func processMarkdown(text string, ctx *Context) error {
+	nodes, err := htmlToWordNodes(markdownToHtml(text), ctx)
+	if err != nil {
+		return err
+	}
+	ctx.pendingHtmlNodes = nodes
+	return nil
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of the parts holding the notes added by the FOOTNOTE and COMMENT commands
+const (
+	FOOTNOTES_KIND = "footnotes"
+	COMMENTS_KIND  = "comments"
+)
+
+// Note is a footnote or a comment added by a FOOTNOTE or COMMENT command
+type Note struct {
+	Id       int
+	Text     string
+	Author   string    // comments only
+	Initials string    // comments only
+	Date     time.Time // comments only
+}
+
+type CommentPars struct {
+	Text     string
+	Author   string    // optional
+	Initials string    // optional
+	Date     time.Time // optional
+}
+
+// MaxNoteId returns the highest id of the notes of a footnotes or comments part, or 0
+func MaxNoteId(part Node) int {
+	maxId := 0
+	for _, child := range part.Children() {
+		if note, ok := child.(*NonTextNode); ok {
+			if id, err := strconv.Atoi(note.Attrs["w:id"]); err == nil {
+				maxId = max(maxId, id)
+			}
+		}
+	}
+	return maxId
+}
+
+// AddNotes appends footnotes or comments to the root of their part, serialized with the given literal XML delimiter
+func AddNotes(part Node, kind string, notes []Note, literalXmlDelimiter string) {
+	node := NewNonTextNode
+	for _, note := range notes {
+		attrs := map[string]string{"w:id": strconv.Itoa(note.Id)}
+		runs := []Node{}
+		tag := "w:comment"
+		if kind == FOOTNOTES_KIND {
+			tag = "w:footnote"
+			runs = append(runs,
+				node(R_TAG, nil, []Node{
+					node(RPR_TAG, nil, []Node{node("w:vertAlign", map[string]string{"w:val": "superscript"}, nil)}),
+					node("w:footnoteRef", nil, nil),
+				}),
+				node(R_TAG, nil, []Node{node(T_TAG, map[string]string{"xml:space": "preserve"}, []Node{NewTextNode(" ")})}),
+			)
+		} else {
+			attrs["w:author"] = note.Author
+			if note.Initials != "" {
+				attrs["w:initials"] = note.Initials
+			}
+			if !note.Date.IsZero() {
+				attrs["w:date"] = note.Date.UTC().Format(time.RFC3339)
+			}
+		}
+		for i, line := range strings.Split(note.Text, "\n") {
+			if i > 0 {
+				runs = append(runs, node(R_TAG, nil, []Node{node("w:br", nil, nil)}))
+			}
+			runs = append(runs, node(R_TAG, nil, []Node{node(T_TAG, map[string]string{"xml:space": "preserve"}, []Node{escapedTextNode(line, literalXmlDelimiter)})}))
+		}
+		AddChild(part, node(tag, attrs, []Node{node(P_TAG, nil, runs)}))
+	}
+}
+
+// commentParsFrom converts the result of a COMMENT expression: a text, a *CommentPars,
+// or a map with text, author and initials keys
+func commentParsFrom(varValue VarValue) (*CommentPars, bool) {
+	switch value := varValue.(type) {
+	case *CommentPars:
+		return value, value != nil
+	case CommentPars:
+		return &value, true
+	case string:
+		return &CommentPars{Text: value}, true
+	case map[string]any:
+		text, hasText := value["text"].(string)
+		author, _ := value["author"].(string)
+		initials, _ := value["initials"].(string)
+		if hasText {
+			return &CommentPars{Text: text, Author: author, Initials: initials}, true
+		}
+	}
+	return nil, false
+}
+
+// processFootnote registers a footnote and returns the literal XML of its reference run,
+// splitting the current run around it
+func processFootnote(ctx *Context, text string) string {
+	ctx.session.footnoteId += 1
+	id := ctx.session.footnoteId
+	ctx.footnotes = append(ctx.footnotes, Note{Id: id, Text: text})
+
+	runProps := ""
+	if ctx.textRunPropsNode != nil {
+		runProps = string(BuildXml(ctx.textRunPropsNode, XmlOptions{LiteralXmlDelimiter: ctx.options.LiteralXmlDelimiter}, " "))
+	}
+	literalXmlDelimiter := ctx.options.LiteralXmlDelimiter
+	return literalXmlDelimiter +
+		`</w:t></w:r><w:r><w:rPr><w:vertAlign w:val="superscript"/></w:rPr>` +
+		fmt.Sprintf(`<w:footnoteReference w:id="%d"/></w:r><w:r>`, id) +
+		runProps + `<w:t xml:space="preserve">` +
+		literalXmlDelimiter
+}
+
+// processComment registers a comment, anchored to the paragraph when leaving it
+func processComment(ctx *Context, commentPars *CommentPars) {
+	ctx.session.commentId += 1
+	ctx.pendingComments = append(ctx.pendingComments, Note{
+		Id:       ctx.session.commentId,
+		Text:     commentPars.Text,
+		Author:   commentPars.Author,
+		Initials: commentPars.Initials,
+		Date:     commentPars.Date,
+	})
+	// Prevent containing paragraph from being removed
+	ctx.buffers[P_TAG].fInsertedText = true
+}
+
+// attachComments marks the whole content of a paragraph as the range of its comments
+func attachComments(paragraph *NonTextNode, comments []Note) {
+	node := NewNonTextNode
+	children := paragraph.Children()
+	insertAt := 0
+	if len(children) > 0 {
+		if first, ok := children[0].(*NonTextNode); ok && first.Tag == "w:pPr" {
+			insertAt = 1
+		}
+	}
+	starts := []Node{}
+	ends := []Node{}
+	for _, comment := range comments {
+		id := map[string]string{"w:id": strconv.Itoa(comment.Id)}
+		starts = append(starts, node("w:commentRangeStart", id, nil))
+		ends = append(ends,
+			node("w:commentRangeEnd", id, nil),
+			node(R_TAG, nil, []Node{node("w:commentReference", id, nil)}),
+		)
+	}
+	newChildren := slices.Concat(children[:insertAt], starts, children[insertAt:], ends)
+	for _, child := range newChildren {
+		child.SetParent(paragraph)
+	}
+	paragraph.SetChildren(newChildren)
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Numbering is the numbering of a list converted from HTML
+type Numbering struct {
+	NumId   int
+	Ordered bool
+	Level   int // 0 for a top level list
+	Start   int // first number of an ordered list
+}
+
+// MaxNumberingId returns the highest value of the given attribute (w:numId or w:abstractNumId)
+// on the children of a numbering part, or 0
+func MaxNumberingId(numbering Node, attr string) int {
+	maxId := 0
+	for _, child := range numbering.Children() {
+		if num, ok := child.(*NonTextNode); ok {
+			if id, err := strconv.Atoi(num.Attrs[attr]); err == nil {
+				maxId = max(maxId, id)
+			}
+		}
+	}
+	return maxId
+}
+
+var (
+	bulletTexts        = []string{"•", "◦", "▪"}
+	orderedListFormats = []string{"decimal", "lowerLetter", "lowerRoman"}
+)
+
+// listAbstractNum returns the definition of the 9 levels of the bullet or ordered lists
+func listAbstractNum(abstractNumId int, ordered bool) *NonTextNode {
+	node := NewNonTextNode
+	levels := []Node{}
+	for ilvl := range 9 {
+		format, text := "bullet", bulletTexts[ilvl%len(bulletTexts)]
+		if ordered {
+			format, text = orderedListFormats[ilvl%len(orderedListFormats)], fmt.Sprintf("%%%d.", ilvl+1)
+		}
+		levels = append(levels, node("w:lvl", map[string]string{"w:ilvl": strconv.Itoa(ilvl)}, []Node{
+			node("w:start", map[string]string{"w:val": "1"}, nil),
+			node("w:numFmt", map[string]string{"w:val": format}, nil),
+			node("w:lvlText", map[string]string{"w:val": text}, nil),
+			node("w:lvlJc", map[string]string{"w:val": "left"}, nil),
+			node("w:pPr", nil, []Node{
+				node("w:ind", map[string]string{"w:left": strconv.Itoa(720 * (ilvl + 1)), "w:hanging": "360"}, nil),
+			}),
+		}))
+	}
+	return node("w:abstractNum", map[string]string{"w:abstractNumId": strconv.Itoa(abstractNumId)}, levels)
+}
+
+// AddNumberings adds the numberings of the lists converted from HTML to a numbering part,
+// with the definitions of their bullet and ordered levels
+func AddNumberings(numbering Node, numberings []Numbering) {
+	node := NewNonTextNode
+	bulletId := MaxNumberingId(numbering, "w:abstractNumId") + 1
+	orderedId := bulletId + 1
+
+	// w:abstractNum elements come before the w:num ones, and w:numIdMacAtCleanup is the last element
+	children := numbering.Children()
+	indexOf := func(tags ...string) int {
+		index := slices.IndexFunc(children, func(child Node) bool {
+			nonTextNode, ok := child.(*NonTextNode)
+			return ok && slices.Contains(tags, nonTextNode.Tag)
+		})
+		if index == -1 {
+			return len(children)
+		}
+		return index
+	}
+	abstractNumsAt := indexOf("w:num", "w:numIdMacAtCleanup")
+	numsAt := indexOf("w:numIdMacAtCleanup")
+	abstractNums := []Node{}
+	if slices.ContainsFunc(numberings, func(n Numbering) bool { return !n.Ordered }) {
+		abstractNums = append(abstractNums, listAbstractNum(bulletId, false))
+	}
+	if slices.ContainsFunc(numberings, func(n Numbering) bool { return n.Ordered }) {
+		abstractNums = append(abstractNums, listAbstractNum(orderedId, true))
+	}
+	nums := []Node{}
+	for _, n := range numberings {
+		abstractNumId := bulletId
+		overrides := []Node{}
+		if n.Ordered {
+			// each ordered list restarts its numbering
+			abstractNumId = orderedId
+			overrides = append(overrides, node("w:lvlOverride", map[string]string{"w:ilvl": strconv.Itoa(n.Level)}, []Node{
+				node("w:startOverride", map[string]string{"w:val": strconv.Itoa(n.Start)}, nil),
+			}))
+		}
+		nums = append(nums, node("w:num", map[string]string{"w:numId": strconv.Itoa(n.NumId)}, append([]Node{
+			node("w:abstractNumId", map[string]string{"w:val": strconv.Itoa(abstractNumId)}, nil),
+		}, overrides...)))
+	}
+
+	newChildren := slices.Concat(children[:abstractNumsAt], abstractNums, children[abstractNumsAt:numsAt], nums, children[numsAt:])
+	for _, child := range newChildren {
+		child.SetParent(numbering)
+	}
+	numbering.SetChildren(newChildren)
+}
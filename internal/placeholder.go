@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// SVG_BLIP_EXTENSION_URI identifies the `a:blip` extension referencing an SVG image
+const SVG_BLIP_EXTENSION_URI = "{96DAC541-7B7A-43D3-8B79-37D633B846F1}"
+
+// placeholderImage is the image replacing a placeholder image of the template
+type placeholderImage struct {
+	command  string // alt text or title of the placeholder
+	relId    string // relationship of the picture
+	svgRelId string // relationship of the SVG image, if any, the picture being its thumbnail
+	pars     *ImagePars
+}
+
+// boundImage returns the image replacing the picture of a wp:docPr whose alt text or title
+// is a command, e.g. +++logo+++ or +++IMAGE logo+++. A nil image keeps the placeholder.
+func boundImage(docPr *NonTextNode, ctx *Context, data any) (*placeholderImage, error) {
+	delimiters := ctx.options.CmdDelimiter
+	for _, attr := range []string{"descr", "title"} {
+		command := strings.TrimSpace(docPr.Attrs[attr])
+		code, ok := strings.CutPrefix(command, delimiters.Open)
+		if !ok {
+			continue
+		}
+		code, ok = strings.CutSuffix(code, delimiters.Close)
+		if !ok || strings.Contains(code, delimiters.Open) {
+			continue
+		}
+		code = strings.TrimSpace(code)
+		if rest, ok := strings.CutPrefix(code, "IMAGE "); ok {
+			code = rest
+		}
+		varValue, err := runAndGetValue(code, ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		var imagePars *ImagePars
+		switch value := varValue.(type) {
+		case nil:
+			return nil, nil
+		case *ImagePars:
+			imagePars = value
+		case ImagePars:
+			imagePars = &value
+		}
+		if imagePars == nil {
+			return nil, errors.New("Not an image as result of " + code)
+		}
+		if err := validateImagePars(imagePars); err != nil {
+			return nil, fmt.Errorf("ImageError: %w", err)
+		}
+		relId, svgRelId, err := imageRelIds(ctx, imagePars)
+		if err != nil {
+			return nil, fmt.Errorf("ImageError: %w", err)
+		}
+		return &placeholderImage{command: docPr.Attrs[attr], relId: relId, svgRelId: svgRelId, pars: imagePars}, nil
+	}
+	return nil, nil
+}
+
+// replacePlaceholderImage retargets the pictures of a wp:inline or wp:anchor to the image replacing
+// the placeholder, keeping the frame, and scales the frame to the aspect ratio of the image with Fit
+func replacePlaceholderImage(drawing *NonTextNode, image *placeholderImage) {
+	for _, blip := range descendants(drawing, "a:blip") {
+		// the attributes are shared with the template node
+		blip.Attrs = maps.Clone(blip.Attrs)
+		blip.Attrs["r:embed"] = image.relId
+		setSvgBlip(blip, image.svgRelId)
+	}
+
+	// the command isn't a description of the image
+	for _, tag := range []string{DOCPR_TAG, "pic:cNvPr"} {
+		for _, element := range descendants(drawing, tag) {
+			element.Attrs = maps.Clone(element.Attrs)
+			for _, attr := range []string{"descr", "title"} {
+				if element.Attrs[attr] != image.command {
+					continue
+				}
+				if image.pars.Alt != "" {
+					element.Attrs[attr] = image.pars.Alt
+				} else {
+					delete(element.Attrs, attr)
+				}
+			}
+		}
+	}
+
+	if image.pars.Fit {
+		if ratio, ok := imageAspectRatio(image.pars); ok {
+			fitExtent(drawing, float64(ratio))
+		}
+	}
+}
+
+// setSvgBlip references the SVG image from the extensions of a blip, or removes the SVG of the placeholder
+func setSvgBlip(blip *NonTextNode, svgRelId string) {
+	extensions := firstChildElement(blip, "a:extLst")
+	if extensions == nil {
+		if svgRelId == "" {
+			return
+		}
+		extensions = NewNonTextNode("a:extLst", nil, nil)
+		extensions.SetParent(blip)
+		blip.AddChild(extensions)
+	}
+	children := slices.DeleteFunc(slices.Clone(extensions.Children()), func(child Node) bool {
+		extension, ok := child.(*NonTextNode)
+		return ok && extension.Attrs["uri"] == SVG_BLIP_EXTENSION_URI
+	})
+	if svgRelId != "" {
+		extension := svgBlipExtension(svgRelId)
+		extension.SetParent(extensions)
+		children = append(children, extension)
+	}
+	extensions.SetChildren(children)
+}
+
+// fitExtent shrinks the extent of a drawing and of its pictures to the given aspect ratio (width / height)
+func fitExtent(drawing *NonTextNode, ratio float64) {
+	extent := firstChildElement(drawing, "wp:extent")
+	if extent == nil {
+		return
+	}
+	var cx, cy int
+	if _, err := fmt.Sscan(extent.Attrs["cx"], &cx); err != nil {
+		return
+	}
+	if _, err := fmt.Sscan(extent.Attrs["cy"], &cy); err != nil {
+		return
+	}
+	if cx == 0 || cy == 0 {
+		return
+	}
+	if float64(cx)/float64(cy) > ratio {
+		cx = int(float64(cy) * ratio)
+	} else {
+		cy = int(float64(cx) / ratio)
+	}
+	extents := []*NonTextNode{extent}
+	for _, shapeProps := range descendants(drawing, "pic:spPr") {
+		if xfrm := firstChildElement(shapeProps, "a:xfrm"); xfrm != nil {
+			if ext := firstChildElement(xfrm, "a:ext"); ext != nil {
+				extents = append(extents, ext)
+			}
+		}
+	}
+	for _, ext := range extents {
+		ext.Attrs = maps.Clone(ext.Attrs)
+		ext.Attrs["cx"] = fmt.Sprint(cx)
+		ext.Attrs["cy"] = fmt.Sprint(cy)
+	}
+}
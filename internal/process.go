@@ -1,21 +1,27 @@
 package internal
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"reflect"
 	"regexp"
 	"slices"
-	"strconv"
 	"strings"
 )
 
 type ReportOutput struct {
-	Report Node
-	Images Images
-	Links  Links
-	Htmls  Htmls
+	Report       Node
+	Images       Images
+	Links        Links
+	Htmls        Htmls
+	Charts       Charts
+	ChartUpdates ChartUpdates // data of the charts of the template bound by their alt text or title
+	Footnotes    []Note
+	Comments     []Note
+	Numberings   []Numbering
 }
 
 type ReportData map[string]any
@@ -46,7 +52,7 @@ func (rd ReportData) GetImage(key string) (*ImagePars, bool) {
 	return nil, false
 }
 
-type CommandProcessor func(data *ReportData, node Node, ctx *Context) (string, error)
+type CommandProcessor func(data any, node Node, ctx *Context) (string, error)
 
 var (
 	IncompleteConditionalStatementError = errors.New("IncompleteConditionalStatementError")
@@ -57,21 +63,31 @@ var (
 		"FOR",
 		"END-FOR",
 		"IF",
+		"ELSE-IF",
+		"ELSE",
 		"END-IF",
 		"INS",
 		"IMAGE",
 		"LINK",
 		"HTML",
+		"MARKDOWN",
+		"TABLE",
+		"CHART",
+		"FOOTNOTE",
+		"COMMENT",
+		"STYLE",
+		"CSTYLE",
 	}
 )
 
-func ProduceReport(data *ReportData, template Node, ctx Context) (*ReportOutput, error) {
+func ProduceReport(data any, template Node, ctx Context) (*ReportOutput, error) {
 	return walkTemplate(data, template, &ctx, processCmd)
 }
 
 func notBuiltIns(cmd string) bool {
-	upperCmd := strings.ToUpper(cmd)
-	return !slices.ContainsFunc(BUILT_IN_COMMANDS, func(b string) bool { return strings.HasPrefix(upperCmd, b) })
+	// compare the whole command name, so that e.g. `elsewhere` or `ifs` are inserted as variables
+	cmdName, _ := splitCommand(cmd)
+	return !slices.Contains(BUILT_IN_COMMANDS, cmdName)
 }
 
 func getCommand(command string, shorthands map[string]string, fixSmartQuotes bool) (string, error) {
@@ -126,7 +142,7 @@ func splitCommand(cmd string) (cmdName string, rest string) {
 	return
 }
 
-func processForIf(data *ReportData, node Node, ctx *Context, cmd string, cmdName string, cmdRest string) error {
+func processForIf(data any, node Node, ctx *Context, cmd string, cmdName string, cmdRest string) error {
 	isIf := cmdName == "IF"
 
 	var forMatch []string
@@ -135,7 +151,6 @@ func processForIf(data *ReportData, node Node, ctx *Context, cmd string, cmdName
 	if isIf {
 		if node.Name() == "" {
 			node.SetName("__if_" + fmt.Sprint(ctx.gCntIf))
-			ctx.gCntIf++
 		}
 		varName = node.Name()
 	} else {
@@ -157,6 +172,7 @@ func processForIf(data *ReportData, node Node, ctx *Context, cmd string, cmdName
 					return NewInvalidCommandError("Duplicate IF statement", cmd)
 				}
 			}
+			ctx.gCntIf++
 		}
 
 		parentLoopLevel := len(ctx.loops) - 1
@@ -171,45 +187,33 @@ func processForIf(data *ReportData, node Node, ctx *Context, cmd string, cmdName
 			if err != nil {
 				return err
 			}
-			// Determine whether to execute the IF block based on the condition result
-			if shouldRun != nil {
-				// Handle all non-nil values uniformly
-				switch v := shouldRun.(type) {
-				case bool:
-					if v {
-						loopOver = []VarValue{1}
-					} else {
-						loopOver = []VarValue{}
-					}
-				case string:
-					if v != "" {
-						loopOver = []VarValue{1}
-					} else {
-						loopOver = []VarValue{}
-					}
-				default:
-					// For numeric types and other types, treat as true if not nil
-					loopOver = []VarValue{1}
-				}
+			if isTruthy(shouldRun) {
+				loopOver = []VarValue{1}
 			} else {
-				// nil value is treated as false
 				loopOver = []VarValue{}
 			}
 		} else {
 			if forMatch == nil {
 				return errors.New("Invalid FOR command")
 			}
-			items, err := runAndGetValue(forMatch[2], ctx, data)
+			clauses, err := parseLoopClauses(forMatch[2], ctx.session.parseCache)
 			if err != nil {
-				return fmt.Errorf("Invalid FOR command (can only iterate over Array) %s: %w", forMatch[2], err)
+				return err
+			}
+			items, err := runAndGetValue(clauses.source, ctx, data)
+			if err != nil {
+				return fmt.Errorf("Invalid FOR command %s: %w", clauses.source, err)
 			}
-			reflected := reflect.ValueOf(items)
-			if reflected.Kind() != reflect.Slice {
-				return fmt.Errorf("Invalid FOR command (can only iterate over Array) %s: %v", forMatch[2], reflected.Kind())
+			loopOver, err = getLoopItems(items, ctx)
+			if err != nil {
+				if isFatalError(err, ctx) {
+					return err
+				}
+				return fmt.Errorf("Invalid FOR command %s: %w", clauses.source, err)
 			}
-			for i := 0; i < reflected.Len(); i++ {
-				item := reflected.Index(i).Interface()
-				loopOver = append(loopOver, item)
+			loopOver, err = applyLoopClauses(loopOver, clauses, varName, ctx, data)
+			if err != nil {
+				return fmt.Errorf("Invalid FOR command %s: %w", forMatch[2], err)
 			}
 		}
 		// For IF statements and FOR loops in the same text node, immediately set idx to 0
@@ -231,6 +235,8 @@ func processForIf(data *ReportData, node Node, ctx *Context, cmd string, cmdName
 			loopOver:     loopOver,
 			isIf:         isIf,
 			idx:          initialIdx,
+			// when the parent is exploring, no branch of this IF may run
+			branchTaken: fParentIsExploring || len(loopOver) > 0,
 		})
 	}
 	logLoop(ctx.loops)
@@ -271,12 +277,9 @@ func processEndForIf(node Node, ctx *Context, cmd string, cmdName string, cmdRes
 	// First time we visit an END-IF node, we assign it the arbitrary name
 	// generated when the IF was processed.
 	// When IF and END-IF are in the same text node, the name is already set
-	// by processForIf, but we still need to count the END-IF.
-	if isIf {
-		if node.Name() == "" {
-			node.SetName(curLoop.varName)
-		}
-		ctx.gCntEndIf += 1
+	// by processForIf.
+	if isIf && node.Name() == "" {
+		node.SetName(curLoop.varName)
 	}
 
 	// For END-IF, we don't need to check the variable name
@@ -292,18 +295,18 @@ func processEndForIf(node Node, ctx *Context, cmd string, cmdName string, cmdRes
 
 	// Get the next item in the loop
 	nextIdx := curLoop.idx + 1
-	var nextItem VarValue
-	if nextIdx < len(curLoop.loopOver) {
-		nextItem = curLoop.loopOver[nextIdx]
-	}
 
-	if nextItem != nil {
-		// next iteration
-		if !isIf {
-			ctx.vars["$"+varName] = nextItem
-			ctx.vars["$idx"] = nextIdx
+	// An IF is never repeated: ELSE and ELSE-IF may have changed its idx,
+	// so END-IF always closes it.
+	if nextIdx < len(curLoop.loopOver) && !curLoop.isIf {
+		// next iteration; the first item is counted too, when leaving the exploration (idx -1)
+		nextItem := curLoop.loopOver[nextIdx]
+		ctx.session.loopIterations++
+		if maxIterations := ctx.options.MaximumLoopIterations; maxIterations > 0 && ctx.session.loopIterations > maxIterations {
+			return &LoopIterationsExceededError{Limit: maxIterations}
 		}
-		// When FOR/END-FOR or IF/END-IF are in the same text node, we can't use the
+		setLoopVars(ctx, varName, nextItem, nextIdx, len(curLoop.loopOver))
+		// When FOR/END-FOR are in the same text node, we can't use the
 		// jump mechanism (which works at the walker level, re-processing children of
 		// the refNode). Instead, we set fContinueLoop to signal processText to
 		// re-process the segments from the beginning.
@@ -315,15 +318,202 @@ func processEndForIf(node Node, ctx *Context, cmd string, cmdName string, cmdRes
 		curLoop.idx = nextIdx
 	} else {
 		// loop finished
+		if curLoop.isIf {
+			ctx.gCntEndIf++
+		}
 		// ctx.loops.pop()
 		ctx.loops = ctx.loops[:len(ctx.loops)-1]
+		if !curLoop.isIf {
+			restoreLoopIdx(ctx)
+		}
+	}
+
+	return nil
+}
+
+// getLoopItems collects the items a FOR loop iterates over: the elements of a slice
+// or an array, the entries of a map (as MapEntry, sorted by key), the values received
+// from a channel until it is closed, or the values yielded by an iter.Seq or iter.Seq2
+// (as MapEntry).
+// Channels and iterators are consumed once, so they can't produce more than
+// MaximumLoopIterations items.
+func getLoopItems(items any, ctx *Context) ([]VarValue, error) {
+	loopOver := []VarValue{}
+	maxIterations := ctx.options.MaximumLoopIterations
+	add := func(item VarValue) error {
+		if maxIterations > 0 && len(loopOver) >= maxIterations {
+			return &LoopIterationsExceededError{Limit: maxIterations}
+		}
+		loopOver = append(loopOver, item)
+		return ctx.session.Err()
+	}
+
+	reflected := reflect.ValueOf(items)
+	// like a nil slice or map, a nil channel or iterator has no items
+	if kind := reflected.Kind(); (kind == reflect.Chan || kind == reflect.Func) && reflected.IsNil() {
+		return loopOver, nil
+	}
+	switch reflected.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < reflected.Len(); i++ {
+			loopOver = append(loopOver, reflected.Index(i).Interface())
+		}
+	case reflect.Map:
+		keys := reflected.MapKeys()
+		slices.SortFunc(keys, compareMapKeys)
+		for _, key := range keys {
+			loopOver = append(loopOver, MapEntry{Key: key.Interface(), Value: reflected.MapIndex(key).Interface()})
+		}
+	case reflect.Chan:
+		if reflected.Type().ChanDir()&reflect.RecvDir == 0 {
+			return nil, fmt.Errorf("cannot receive from %v", reflected.Type())
+		}
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: reflected},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.session.done)},
+		}
+		for {
+			chosen, item, ok := reflect.Select(cases)
+			if chosen == 1 {
+				return nil, ctx.session.Err()
+			}
+			if !ok {
+				break
+			}
+			if err := add(item.Interface()); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Func:
+		var err error
+		yield, ok := seqYield(reflected.Type(), func(item VarValue) bool {
+			err = add(item)
+			return err == nil
+		})
+		if !ok {
+			return nil, fmt.Errorf("cannot iterate over %v", reflected.Type())
+		}
+		reflected.Call([]reflect.Value{yield})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("cannot iterate over %v", reflected.Kind())
+	}
+	return loopOver, nil
+}
+
+// seqYield builds the yield function of an iter.Seq or iter.Seq2 of any type,
+// calling add with each value, or with a MapEntry for each pair
+func seqYield(seqType reflect.Type, add func(item VarValue) bool) (reflect.Value, bool) {
+	if seqType.NumIn() != 1 || seqType.NumOut() != 0 {
+		return reflect.Value{}, false
+	}
+	yieldType := seqType.In(0)
+	if yieldType.Kind() != reflect.Func || yieldType.NumOut() != 1 || yieldType.Out(0).Kind() != reflect.Bool {
+		return reflect.Value{}, false
+	}
+	var yield func(args []reflect.Value) []reflect.Value
+	switch yieldType.NumIn() {
+	case 1:
+		yield = func(args []reflect.Value) []reflect.Value {
+			return []reflect.Value{reflect.ValueOf(add(args[0].Interface()))}
+		}
+	case 2:
+		yield = func(args []reflect.Value) []reflect.Value {
+			return []reflect.Value{reflect.ValueOf(add(MapEntry{Key: args[0].Interface(), Value: args[1].Interface()}))}
+		}
+	default:
+		return reflect.Value{}, false
+	}
+	return reflect.MakeFunc(yieldType, yield), true
+}
+
+// compareMapKeys orders numbers numerically, strings lexically,
+// and other keys by their text representation
+func compareMapKeys(a, b reflect.Value) int {
+	if aNum, ok := numericValue(a.Interface()); ok {
+		if bNum, ok := numericValue(b.Interface()); ok {
+			return cmp.Compare(aNum, bNum)
+		}
 	}
+	return strings.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+}
+
+// setLoopVars exposes the current item of a FOR loop and its metadata:
+// $<varName>, $<varName>_idx (0-based), $<varName>_num (1-based),
+// $<varName>_first, $<varName>_last, $<varName>_count, and $idx for the innermost loop.
+// The metadata of outer loops stays readable from nested loops.
+func setLoopVars(ctx *Context, varName string, item VarValue, idx int, count int) {
+	prefix := "$" + varName
+	ctx.vars[prefix] = item
+	ctx.vars[prefix+"_idx"] = idx
+	ctx.vars[prefix+"_num"] = idx + 1
+	ctx.vars[prefix+"_first"] = idx == 0
+	ctx.vars[prefix+"_last"] = idx == count-1
+	ctx.vars[prefix+"_count"] = count
+	ctx.vars["$idx"] = idx
+}
 
+// restoreLoopIdx sets $idx back to the index of the innermost running FOR loop,
+// once a nested loop has finished.
+func restoreLoopIdx(ctx *Context) {
+	for i := len(ctx.loops) - 1; i >= 0; i-- {
+		loop := ctx.loops[i]
+		if !loop.isIf && loop.idx >= 0 {
+			ctx.vars["$idx"] = loop.idx
+			return
+		}
+	}
+}
+
+// processElse switches the current IF statement to its ELSE-IF or ELSE branch.
+// A branch only runs if no previous branch of the same IF did.
+func processElse(data any, ctx *Context, cmd string, cmdName string, cmdRest string) error {
+	curLoop := getCurLoop(ctx)
+	if curLoop == nil || !curLoop.isIf {
+		return NewInvalidCommandError(fmt.Sprintf("Unexpected %s outside of IF statement", cmdName), cmd)
+	}
+	if curLoop.elseSeen {
+		return NewInvalidCommandError(fmt.Sprintf("Unexpected %s after ELSE", cmdName), cmd)
+	}
+	if cmdName == "ELSE" {
+		curLoop.elseSeen = true
+	}
+
+	if curLoop.branchTaken {
+		// the text emitted so far by the taken branch stays in the current paragraph or row
+		if curLoop.idx != -1 {
+			for _, tag := range []string{P_TAG, TR_TAG, TC_TAG} {
+				ctx.buffers[tag].fKeep = true
+			}
+		}
+		curLoop.idx = -1
+		return nil
+	}
+
+	shouldRun := true
+	if cmdName == "ELSE-IF" {
+		value, err := runAndGetValue(cmdRest, ctx, data)
+		if err != nil {
+			return err
+		}
+		shouldRun = isTruthy(value)
+	}
+	if shouldRun {
+		curLoop.idx = 0
+		curLoop.branchTaken = true
+	} else {
+		curLoop.idx = -1
+	}
 	return nil
 }
 
 func validateImagePars(pars *ImagePars) error {
 	err := validateExtension(pars.Extension)
+	if err == nil && pars.Anchor != nil {
+		err = validateImageAnchor(pars.Anchor)
+	}
 	return err
 }
 
@@ -334,13 +524,17 @@ func validateExtension(ext string) error {
 	return nil
 }
 
-func imageToContext(ctx *Context, img *Image) string {
+func imageToContext(ctx *Context, img *Image) (string, error) {
 	// TODO revalidate ? validateImage(img)
-	ctx.imageAndShapeIdIncrement += 1
-	id := fmt.Sprint(ctx.imageAndShapeIdIncrement)
+	ctx.session.imageBytes += int64(len(img.Data))
+	if maxBytes := ctx.options.MaximumImageBytes; maxBytes > 0 && ctx.session.imageBytes > maxBytes {
+		return "", &ImageBytesExceededError{Limit: maxBytes}
+	}
+	ctx.session.imageAndShapeIdIncrement += 1
+	id := fmt.Sprint(ctx.session.imageAndShapeIdIncrement)
 	relId := fmt.Sprintf("img%s", id)
 	ctx.images[relId] = img
-	return relId
+	return relId, nil
 }
 
 func getImageData(pars *ImagePars) *Image {
@@ -350,17 +544,89 @@ func getImageData(pars *ImagePars) *Image {
 	}
 }
 
+// imageRelIds adds an image to the context, and returns the relationship of the picture and,
+// for an SVG image, the one of the SVG, the picture being its thumbnail
+func imageRelIds(ctx *Context, imagePars *ImagePars) (string, string, error) {
+	imgRelId, err := imageToContext(ctx, getImageData(imagePars))
+	if err != nil {
+		return "", "", err
+	}
+	if ctx.images[imgRelId].Extension != ".svg" {
+		return imgRelId, "", nil
+	}
+	// Default to an empty thumbnail, as it is not critical and just part of the docx standard's scaffolding.
+	// Without a thumbnail, the svg won't render (even in newer versions of Word that don't need the thumbnail).
+	thumbnail := imagePars.Thumbnail
+	if thumbnail == nil {
+		thumbnail = &Thumbnail{
+			Image: Image{Extension: ".png", Data: []byte{110, 111, 74, 68, 69, 110, 67, 10}},
+		}
+	}
+	thumbRelId, err := imageToContext(ctx, &thumbnail.Image)
+	if err != nil {
+		return "", "", err
+	}
+	// For SVG the thumb is placed where the image normally goes.
+	return thumbRelId, imgRelId, nil
+}
+
+// svgBlipExtension returns the `a:blip` extension referencing an SVG image
+func svgBlipExtension(svgRelId string) *NonTextNode {
+	node := NewNonTextNode
+	return node("a:ext", map[string]string{
+		"uri": SVG_BLIP_EXTENSION_URI,
+	}, []Node{
+		node("asvg:svgBlip", map[string]string{
+			"xmlns:asvg": "http://schemas.microsoft.com/office/drawing/2016/SVG/main",
+			"r:embed":    svgRelId,
+		}, nil),
+	})
+}
+
 func processImage(ctx *Context, imagePars *ImagePars) error {
-	err := validateImagePars(imagePars)
+	drawing, err := imageDrawing(ctx, imagePars)
 	if err != nil {
 		return err
 	}
 
-	cx := int(imagePars.Width * 360e3)
-	cy := int(imagePars.Height * 360e3)
+	node := NewNonTextNode
+	ctx.pendingImageNode = &struct {
+		image   *NonTextNode
+		caption []*NonTextNode
+	}{
+		image:   drawing,
+		caption: nil,
+	}
+
+	if imagePars.Caption != "" {
+		ctx.pendingImageNode.caption = []*NonTextNode{
+			node("w:br", map[string]string{}, nil),
+			node("w:t", map[string]string{}, []Node{NewTextNode(imagePars.Caption)}),
+		}
+	}
+
+	return nil
+}
+
+// imageDrawing adds an image to the context, and returns the `w:drawing` node displaying it
+func imageDrawing(ctx *Context, imagePars *ImagePars) (*NonTextNode, error) {
+	err := validateImagePars(imagePars)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height, err := imageDisplaySize(ctx, imagePars)
+	if err != nil {
+		return nil, err
+	}
+	cx := int(width * 360e3)
+	cy := int(height * 360e3)
 
-	imgRelId := imageToContext(ctx, getImageData(imagePars))
-	id := fmt.Sprint(ctx.imageAndShapeIdIncrement)
+	imgRelId, svgRelId, err := imageRelIds(ctx, imagePars)
+	if err != nil {
+		return nil, err
+	}
+	id := fmt.Sprint(ctx.session.imageAndShapeIdIncrement)
 	alt := imagePars.Alt
 	if alt == "" {
 		alt = "desc"
@@ -383,26 +649,8 @@ func processImage(ctx *Context, imagePars *ImagePars) error {
 		rot = fmt.Sprintf("-%d", imagePars.Rotation*60e3)
 	}
 
-	if ctx.images[imgRelId].Extension == ".svg" {
-		// Default to an empty thumbnail, as it is not critical and just part of the docx standard's scaffolding.
-		// Without a thumbnail, the svg won't render (even in newer versions of Word that don't need the thumbnail).
-		thumbnail := imagePars.Thumbnail
-		if thumbnail == nil {
-			thumbnail = &Thumbnail{
-				Image: Image{Extension: ".png", Data: []byte{110, 111, 74, 68, 69, 110, 67, 10}},
-			}
-		}
-		thumbRelId := imageToContext(ctx, &thumbnail.Image)
-		extNodes = append(extNodes, node("a:ext", map[string]string{
-			"uri": "{96DAC541-7B7A-43D3-8B79-37D633B846F1}",
-		}, []Node{
-			node("asvg:svgBlip", map[string]string{
-				"xmlns:asvg": "http://schemas.microsoft.com/office/drawing/2016/SVG/main",
-				"r:embed":    imgRelId,
-			}, nil),
-		}))
-		// For SVG the thumb is placed where the image normally goes.
-		imgRelId = thumbRelId
+	if svgRelId != "" {
+		extNodes = append(extNodes, svgBlipExtension(svgRelId))
 	}
 
 	rotAttrs := map[string]string{}
@@ -438,47 +686,35 @@ func processImage(ctx *Context, imagePars *ImagePars) error {
 			}),
 		},
 	)
-	drawing := node("w:drawing", map[string]string{}, []Node{
-		node("wp:inline", map[string]string{"distT": "0", "distB": "0", "distL": "0", "distR": "0"}, []Node{
-			node("wp:extent", map[string]string{"cx": fmt.Sprint(cx), "cy": fmt.Sprint(cy)}, nil),
-			node("wp:docPr", map[string]string{"id": id, "name": `Picture ` + id, "descr": alt}, nil),
-			node("wp:cNvGraphicFramePr", map[string]string{}, []Node{
-				node("a:graphicFrameLocks", map[string]string{
-					"xmlns:a":        "http://schemas.openxmlformats.org/drawingml/2006/main",
-					"noChangeAspect": "1",
-				}, nil),
-			}),
-			node(
-				"a:graphic",
-				map[string]string{"xmlns:a": "http://schemas.openxmlformats.org/drawingml/2006/main"},
-				[]Node{
-					node(
-						"a:graphicData",
-						map[string]string{"uri": "http://schemas.openxmlformats.org/drawingml/2006/picture"},
-						[]Node{pic},
-					),
-				},
-			),
+	extent := node("wp:extent", map[string]string{"cx": fmt.Sprint(cx), "cy": fmt.Sprint(cy)}, nil)
+	frame := []Node{
+		node("wp:docPr", map[string]string{"id": id, "name": `Picture ` + id, "descr": alt}, nil),
+		node("wp:cNvGraphicFramePr", map[string]string{}, []Node{
+			node("a:graphicFrameLocks", map[string]string{
+				"xmlns:a":        "http://schemas.openxmlformats.org/drawingml/2006/main",
+				"noChangeAspect": "1",
+			}, nil),
 		}),
-	})
-
-	ctx.pendingImageNode = &struct {
-		image   *NonTextNode
-		caption []*NonTextNode
-	}{
-		image:   drawing,
-		caption: nil,
+		node(
+			"a:graphic",
+			map[string]string{"xmlns:a": "http://schemas.openxmlformats.org/drawingml/2006/main"},
+			[]Node{
+				node(
+					"a:graphicData",
+					map[string]string{"uri": "http://schemas.openxmlformats.org/drawingml/2006/picture"},
+					[]Node{pic},
+				),
+			},
+		),
 	}
 
-	if imagePars.Caption != "" {
-		ctx.pendingImageNode.caption = []*NonTextNode{
-			node("w:br", map[string]string{}, nil),
-			node("w:t", map[string]string{}, []Node{NewTextNode(imagePars.Caption)}),
-		}
+	if imagePars.Anchor != nil {
+		return node("w:drawing", map[string]string{}, []Node{imageAnchor(imagePars.Anchor, extent, frame)}), nil
 	}
-
-	return nil
+	inline := node("wp:inline", map[string]string{"distT": "0", "distB": "0", "distL": "0", "distR": "0"}, append([]Node{extent}, frame...))
+	return node("w:drawing", map[string]string{}, []Node{inline}), nil
 }
+
 func processLink(ctx *Context, linkPars *LinkPars) error {
 	url := linkPars.Url
 	label := linkPars.Label
@@ -486,13 +722,7 @@ func processLink(ctx *Context, linkPars *LinkPars) error {
 		label = url
 	}
 
-	ctx.linkId += 1
-	id := fmt.Sprint(ctx.linkId)
-	relId := "link" + id
-
-	ctx.links[relId] = Link{
-		url: url,
-	}
+	relId := linkToContext(ctx, url)
 
 	node := NewNonTextNode
 	textRunPropsNode := ctx.textRunPropsNode
@@ -514,6 +744,18 @@ func processLink(ctx *Context, linkPars *LinkPars) error {
 	return nil
 }
 
+// linkToContext adds a hyperlink url to the context, and returns its relationship id
+func linkToContext(ctx *Context, url string) string {
+	ctx.linkId += 1
+	id := fmt.Sprint(ctx.linkId)
+	relId := "link" + id
+
+	ctx.links[relId] = Link{
+		url: url,
+	}
+	return relId
+}
+
 func findParentPorTrNode(node Node) (resultNode Node) {
 	parentNode := node.Parent()
 
@@ -539,33 +781,6 @@ func findParentPorTrNode(node Node) (resultNode Node) {
 	return
 }
 
-var functionCallRegexp = regexp.MustCompile(`(\w+)\(([^)]*)\)`)
-
-func parseFunctionCall(rest string) ([]string, bool) {
-	matches := functionCallRegexp.FindStringSubmatch(rest)
-	if len(matches) > 0 {
-		// parse args char by char to handle string containing commas
-		args := []string{}
-		current := strings.Builder{}
-		isInString := false
-		for _, char := range []rune(matches[2]) {
-			if char == '\'' {
-				current.WriteRune(char)
-				isInString = !isInString
-			} else if char == ',' && !isInString {
-				args = append(args, strings.TrimSpace(current.String()))
-				current.Reset()
-			} else {
-				current.WriteRune(char)
-			}
-		}
-		args = append(args, strings.TrimSpace(current.String()))
-
-		return append([]string{matches[1]}, args...), true
-	}
-	return nil, false
-}
-
 func isLink(varValue VarValue) (*LinkPars, bool) {
 	if strMap, ok := varValue.(map[string]any); ok {
 		url, hasUrl := strMap["url"].(string)
@@ -583,154 +798,34 @@ func isLink(varValue VarValue) (*LinkPars, bool) {
 	return nil, false
 }
 
-func getFromVars(ctx *Context, key string) (varValue VarValue, exists bool) {
-	return getValueFrom(key, ctx.vars)
-}
-
-func getValue(key string, ctx *Context, data *ReportData) (VarValue, bool) {
-	key = strings.TrimSpace(key)
-	if key[0] == '$' {
-		return getFromVars(ctx, key)
-	}
-	lastI := len(key) - 1
-	if lastI > 0 && (key[0] == '\'' && key[lastI] == '\'') || (key[0] == '`' && key[lastI] == '`') {
-		return key[1:lastI], true
-	}
-	if number, err := strconv.ParseInt(key, 10, 64); err == nil {
-		return number, true
-	}
-	if number, err := strconv.ParseFloat(key, 64); err == nil {
-		return number, true
-	}
-	return data.GetValue(key)
-}
-
-func runFunction(funcName string, args []string, ctx *Context, data *ReportData) (VarValue, error) {
-	if ctx.options.Functions != nil {
-		if function, ok := ctx.options.Functions[funcName]; ok {
-			argValues := make([]any, len(args))
-			for i, arg := range args {
-				if varValue, ok := getValue(arg, ctx, data); ok {
-					argValues[i] = varValue
-				} else if ctx.options.ErrorHandler != nil {
-					return ctx.options.ErrorHandler(&KeyNotFoundError{Key: arg}, arg), nil
-				} else {
-					return "", &KeyNotFoundError{Key: arg}
-				}
-			}
-			value := function(argValues...)
-			return value, nil
-		} else {
-			return "", &FunctionNotFoundError{FunctionName: funcName}
-		}
-	} else {
-		return "", &FunctionNotFoundError{FunctionName: funcName}
-	}
-}
-
-func runAndGetValue(text string, ctx *Context, data *ReportData) (VarValue, error) {
-	var value VarValue
-	// Process conditional expression
-	// Check comparison operators
-	for _, op := range []string{"==", "!=", ">=", "<=", ">", "<"} {
-		if strings.Contains(text, op) {
-			parts := strings.Split(text, op)
-			if len(parts) == 2 {
-				left, err := runAndGetValue(strings.TrimSpace(parts[0]), ctx, data)
-				if err != nil {
-					return nil, err
-				}
-				right, err := runAndGetValue(strings.TrimSpace(parts[1]), ctx, data)
-				if err != nil {
-					return nil, err
-				}
-
-				// Convert to numeric values for comparison
-				leftNum, leftIsNum := toNumber(left)
-				rightNum, rightIsNum := toNumber(right)
-
-				if leftIsNum && rightIsNum {
-					switch op {
-					case "==":
-						return leftNum == rightNum, nil
-					case ">=":
-						return leftNum >= rightNum, nil
-					case "<=":
-						return leftNum <= rightNum, nil
-					case ">":
-						return leftNum > rightNum, nil
-					case "<":
-						return leftNum < rightNum, nil
-					}
-				} else if op == "==" {
-					// For non-numeric values,handle equality comparison
-					return left == right, nil
-				} else if op == "!=" {
-					// For non-numeric values, handle not equality comparison
-					return left != right, nil
-				}
-			}
-		}
-	}
-
-	if args, isFunction := parseFunctionCall(text); isFunction {
-		funcName := args[0]
-		args = args[1:]
-
-		var err error
-		value, err = runFunction(funcName, args, ctx, data)
-		if err != nil {
-			return "", err
-		}
-	} else if varValue, ok := getValue(text, ctx, data); ok {
-		return varValue, nil
-	} else if ctx.options.ErrorHandler != nil {
-		value = ctx.options.ErrorHandler(&KeyNotFoundError{Key: text}, text)
-	} else {
-		return "", errors.New("Fail to compute value for " + text)
-	}
-	return value, nil
-}
-
-// Helper function: Convert value to float64 for comparison
-func toNumber(v interface{}) (float64, bool) {
-	switch val := v.(type) {
-	case int:
-		return float64(val), true
-	case int32:
-		return float64(val), true
-	case int64:
-		return float64(val), true
-	case float32:
-		return float64(val), true
-	case float64:
-		return val, true
-	case string:
-		if f, err := strconv.ParseFloat(val, 64); err == nil {
-			return f, true
-		}
-	}
-	return 0, false
-}
-
-func processHtml(html string, ctx *Context) {
+func processHtml(html string, ctx *Context, data any) error {
 	interpolationRegex := regexp.MustCompile(`\$\{(.*?)\}`)
 
 	html = interpolationRegex.ReplaceAllStringFunc(html, func(match string) string {
 		key := match[2 : len(match)-1]
-		value, _ := runAndGetValue(key, ctx, nil)
+		value, _ := runAndGetValue(key, ctx, data)
 		return fmt.Sprint(value)
 	})
 
+	if ctx.options.ConvertHtml {
+		nodes, err := htmlToWordNodes(html, ctx)
+		if err != nil {
+			return err
+		}
+		ctx.pendingHtmlNodes = nodes
+		return nil
+	}
+
 	ctx.htmlId += 1
 	id := fmt.Sprint(ctx.htmlId)
 	relId := "html" + id
 	ctx.htmls[relId] = html
 	htmlNode := NewNonTextNode(ALTCHUNK_TAG, map[string]string{"r:id": relId}, nil)
-	ctx.pendingHtmlNode = htmlNode
+	ctx.pendingHtmlNodes = []Node{htmlNode}
+	return nil
 }
 
-func processCmd(data *ReportData, node Node, ctx *Context) (string, error) {
+func processCmd(data any, node Node, ctx *Context) (string, error) {
 	cmd, err := getCommand(ctx.cmd, ctx.shorthands, ctx.options.FixSmartQuotes)
 
 	if err != nil {
@@ -771,6 +866,14 @@ func processCmd(data *ReportData, node Node, ctx *Context) (string, error) {
 			return "", err
 		}
 
+		// ELSE-IF <expression>
+		// ELSE
+	} else if cmdName == "ELSE-IF" || cmdName == "ELSE" {
+		err := processElse(data, ctx, cmd, cmdName, rest)
+		if err != nil {
+			return "", err
+		}
+
 		// END-FOR
 		// END-IF
 	} else if cmdName == "END-FOR" || cmdName == "END-IF" {
@@ -787,6 +890,14 @@ func processCmd(data *ReportData, node Node, ctx *Context) (string, error) {
 			if err != nil {
 				return "", err
 			}
+			switch richText := varValue.(type) {
+			case RichText:
+				return processRichText(ctx, richText), nil
+			case *RichText:
+				if richText != nil {
+					return processRichText(ctx, *richText), nil
+				}
+			}
 			value := fmt.Sprintf("%v", varValue)
 
 			if ctx.options.ProcessLineBreaks {
@@ -843,10 +954,108 @@ func processCmd(data *ReportData, node Node, ctx *Context) (string, error) {
 			if err != nil {
 				return "", err
 			}
-			processHtml(fmt.Sprintf("%v", varValue), ctx)
+			err = processHtml(fmt.Sprintf("%v", varValue), ctx, data)
+			if err != nil {
+				return "", fmt.Errorf("HtmlError: %w", err)
+			}
+			return "", nil
+		}
+
+		// MARKDOWN <expression>
+	} else if cmdName == "MARKDOWN" {
+		if !isLoopExploring(ctx) {
+			varValue, err := runAndGetValue(rest, ctx, data)
+			if err != nil {
+				return "", err
+			}
+			err = processMarkdown(toString(varValue), ctx)
+			if err != nil {
+				return "", fmt.Errorf("MarkdownError: %w", err)
+			}
+			return "", nil
+		}
+
+		// TABLE <expression>
+	} else if cmdName == "TABLE" {
+		if !isLoopExploring(ctx) {
+			varValue, err := runAndGetValue(rest, ctx, data)
+			if err != nil {
+				return "", err
+			}
+			tablePars, ok := tableParsFrom(varValue)
+			if !ok {
+				return "", errors.New("Not a table as result of " + rest)
+			}
+			err = processTable(ctx, tablePars)
+			if err != nil {
+				return "", fmt.Errorf("TableError: %w", err)
+			}
 			return "", nil
 		}
 
+		// CHART <expression>
+	} else if cmdName == "CHART" {
+		if !isLoopExploring(ctx) {
+			varValue, err := runAndGetValue(rest, ctx, data)
+			if err != nil {
+				return "", err
+			}
+			chartPars, ok := chartParsFrom(varValue)
+			if !ok {
+				return "", errors.New("Not a chart as result of " + rest)
+			}
+			err = processChart(ctx, chartPars)
+			if err != nil {
+				return "", fmt.Errorf("ChartError: %w", err)
+			}
+			return "", nil
+		}
+
+		// FOOTNOTE <expression>
+	} else if cmdName == "FOOTNOTE" {
+		if !isLoopExploring(ctx) {
+			varValue, err := runAndGetValue(rest, ctx, data)
+			if err != nil {
+				return "", err
+			}
+			return processFootnote(ctx, toString(varValue)), nil
+		}
+
+		// COMMENT <expression>
+	} else if cmdName == "COMMENT" {
+		if !isLoopExploring(ctx) {
+			varValue, err := runAndGetValue(rest, ctx, data)
+			if err != nil {
+				return "", err
+			}
+			commentPars, ok := commentParsFrom(varValue)
+			if !ok {
+				return "", errors.New("Not a comment as result of " + rest)
+			}
+			processComment(ctx, commentPars)
+		}
+
+		// STYLE <expression>, CSTYLE <expression>
+	} else if cmdName == "STYLE" || cmdName == "CSTYLE" {
+		if !isLoopExploring(ctx) {
+			varValue, err := runAndGetValue(rest, ctx, data)
+			if err != nil {
+				return "", err
+			}
+			// an empty style keeps the style of the template
+			if style := toString(varValue); style != "" {
+				styleId, err := ctx.session.styles.styleId(style)
+				if err != nil {
+					return "", err
+				}
+				if cmdName == "STYLE" {
+					ctx.paragraphStyle = styleId
+				} else {
+					ctx.runStyle = styleId
+				}
+			}
+		}
+
 		// CommandSyntaxError
 	} else {
 		return "", errors.New("CommandSyntaxError: " + cmd)
@@ -865,7 +1074,7 @@ func debugPrintNode(node Node) string {
 		return "<unknown>"
 	}
 }
-func walkTemplate(data *ReportData, template Node, ctx *Context, processor CommandProcessor) (*ReportOutput, error) {
+func walkTemplate(data any, template Node, ctx *Context, processor CommandProcessor) (*ReportOutput, error) {
 	var retErr error
 	out := CloneNodeWithoutChildren(template.(*NonTextNode))
 
@@ -875,10 +1084,15 @@ func walkTemplate(data *ReportData, template Node, ctx *Context, processor Comma
 	deltaJump := 0
 
 	loopCount := 0
-	// TODO get from options
-	maximumWalkingDepth := 1_000_000
+	maximumWalkingDepth := ctx.options.MaximumWalkingDepth
+	if maximumWalkingDepth <= 0 {
+		maximumWalkingDepth = DEFAULT_MAXIMUM_WALKING_DEPTH
+	}
 
 	for {
+		if err := ctx.session.Err(); err != nil {
+			return nil, err
+		}
 		curLoop := getCurLoop(ctx)
 		var nextSibling Node = nil
 
@@ -913,9 +1127,6 @@ func walkTemplate(data *ReportData, template Node, ctx *Context, processor Comma
 			if parent == nil {
 				slog.Debug("=== parent is null, breaking after %s loops...", "loopCount", loopCount)
 				break
-			} else if loopCount > maximumWalkingDepth {
-				slog.Debug("=== parent is still not null after {loopCount} loops, something must be wrong ...", "loopCount", loopCount)
-				return nil, errors.New("infinite loop or massive dataset detected. Please review and try again")
 			}
 			nodeIn = parent
 			ctx.level -= 1
@@ -938,10 +1149,11 @@ func walkTemplate(data *ReportData, template Node, ctx *Context, processor Comma
 			fRemoveNode := false
 
 			// Delete last generated output node if we're skipping nodes due to an empty FOR loop
+			// (but not a paragraph or row holding the output of an IF branch)
 			if (tag == P_TAG ||
 				tag == TBL_TAG ||
 				tag == TR_TAG ||
-				tag == TC_TAG) && isLoopExploring(ctx) {
+				tag == TC_TAG) && isLoopExploring(ctx) && (tag == TBL_TAG || !ctx.buffers[tag].fKeep) {
 				fRemoveNode = true
 				// Delete last generated output node if the user inserted a paragraph
 				// (or table row) with just a command
@@ -956,6 +1168,33 @@ func walkTemplate(data *ReportData, template Node, ctx *Context, processor Comma
 				nodeOut.Parent().PopChild()
 			}
 
+			// Anchor the comments of the paragraph that is left, unless it was removed
+			if tag == P_TAG && len(ctx.pendingComments) > 0 {
+				if !fRemoveNode {
+					attachComments(nonTextNodeOut, ctx.pendingComments)
+					ctx.comments = append(ctx.comments, ctx.pendingComments...)
+				}
+				ctx.pendingComments = nil
+			}
+
+			// Set the styles of STYLE and CSTYLE commands on the run or paragraph that is left
+			if tag == R_TAG && ctx.runStyle != "" {
+				setStyle(nonTextNodeOut, RPR_TAG, "w:rStyle", ctx.runStyle)
+				ctx.runStyle = ""
+			}
+			if tag == P_TAG && ctx.paragraphStyle != "" {
+				if !fRemoveNode {
+					setStyle(nonTextNodeOut, "w:pPr", "w:pStyle", ctx.paragraphStyle)
+				}
+				ctx.paragraphStyle = ""
+			}
+
+			// Replace the picture of the placeholder image that is left
+			if (tag == "wp:inline" || tag == "wp:anchor") && ctx.pendingImage != nil {
+				replacePlaceholderImage(nonTextNodeOut, ctx.pendingImage)
+				ctx.pendingImage = nil
+			}
+
 		}
 
 		// Handle an UP movement
@@ -1018,31 +1257,32 @@ func walkTemplate(data *ReportData, template Node, ctx *Context, processor Comma
 				ctx.pendingLinkNode = nil
 			}
 
-			// If a html page was generated, replace the parent `w:p` node with
-			// the html node
-			if ctx.pendingHtmlNode != nil && isNotTextNode && nonTextNodeOut.Tag == P_TAG {
-				htmlNode := ctx.pendingHtmlNode
+			// If a html page, markdown or a table was generated, replace the parent `w:p` node with
+			// the html nodes
+			if ctx.pendingHtmlNodes != nil && isNotTextNode && nonTextNodeOut.Tag == P_TAG {
 				parent := nodeOut.Parent()
 				if parent != nil {
-					htmlNode.SetParent(parent)
 					// pop last children
 					parent.PopChild()
-					parent.AddChild(htmlNode)
+					for _, htmlNode := range ctx.pendingHtmlNodes {
+						htmlNode.SetParent(parent)
+						parent.AddChild(htmlNode)
+					}
 					// Prevent containing paragraph or table row from being removed
 					ctx.buffers[P_TAG].fInsertedText = true
 					ctx.buffers[TR_TAG].fInsertedText = true
 					ctx.buffers[TC_TAG].fInsertedText = true
 				}
-				ctx.pendingHtmlNode = nil
+				ctx.pendingHtmlNodes = nil
 			}
 
-			// `w:tc` nodes shouldn't be left with no `w:p` or 'w:altChunk' children; if that's the
-			// case, add an empty `w:p` inside
+			// `w:tc` nodes (and notes, comments, headers and footers) shouldn't be left with no `w:p`
+			// or 'w:altChunk' children; if that's the case, add an empty `w:p` inside
 			filterCase := slices.ContainsFunc(nodeOut.Children(), func(node Node) bool {
 				nonTextNode, isNotTextNode := node.(*NonTextNode)
 				return isNotTextNode && (nonTextNode.Tag == P_TAG || nonTextNode.Tag == ALTCHUNK_TAG)
 			})
-			if isNotTextNode && nonTextNodeOut.Tag == TC_TAG && !filterCase {
+			if isNotTextNode && slices.Contains(PARAGRAPH_CONTAINER_TAGS, nonTextNodeOut.Tag) && !filterCase {
 				nodeOut.AddChild(NewNonTextNode(P_TAG, nil, nil))
 			}
 
@@ -1089,6 +1329,32 @@ func walkTemplate(data *ReportData, template Node, ctx *Context, processor Comma
 					slog.Debug("detected a - ", "newNode", debugPrintNode(newNode))
 					updateID(newNode.(*NonTextNode), ctx)
 				}
+
+				// Charts of the template bound to data by their alt text or title
+				if !isLoopExploring(ctx) && newNodeTag == DOCPR_TAG {
+					chartData, err := boundChartData(nodeInNTxt, ctx, data)
+					if err != nil && isFatalError(err, ctx) {
+						return nil, err
+					} else if err != nil {
+						retErr = errors.Join(retErr, err)
+					}
+					ctx.pendingChartData = chartData
+				}
+
+				// Placeholder images of the template bound to data by their alt text or title
+				if !isLoopExploring(ctx) && ctx.options.BindPlaceholderImages && newNodeTag == DOCPR_TAG {
+					image, err := boundImage(nodeInNTxt, ctx, data)
+					if err != nil && isFatalError(err, ctx) {
+						return nil, err
+					} else if err != nil {
+						retErr = errors.Join(retErr, err)
+					}
+					ctx.pendingImage = image
+				}
+				if !isLoopExploring(ctx) && newNodeTag == "c:chart" && ctx.pendingChartData != nil {
+					ctx.chartUpdates[nodeInNTxt.Attrs["r:id"]] = ctx.pendingChartData
+					ctx.pendingChartData = nil
+				}
 			}
 
 			// If it's a text node inside a w:t, process it
@@ -1097,7 +1363,9 @@ func walkTemplate(data *ReportData, template Node, ctx *Context, processor Comma
 			nodeInParentNTxt, isNodeInParentNTxt := parent.(*NonTextNode)
 			if isNodeInTxt && parent != nil && isNodeInParentNTxt && nodeInParentNTxt.Tag == T_TAG {
 				result, err := processText(data, nodeInTxt, ctx, processor)
-				if err != nil {
+				if err != nil && isFatalError(err, ctx) {
+					return nil, err
+				} else if err != nil {
 					retErr = errors.Join(retErr, err)
 				} else {
 					newNode.(*TextNode).Text = result
@@ -1121,6 +1389,10 @@ func walkTemplate(data *ReportData, template Node, ctx *Context, processor Comma
 		}
 
 		loopCount++
+		if loopCount > maximumWalkingDepth {
+			slog.Debug("=== parent is still not null after {loopCount} loops, something must be wrong ...", "loopCount", loopCount)
+			return nil, &WalkingDepthExceededError{Limit: maximumWalkingDepth}
+		}
 	}
 
 	if ctx.gCntIf != ctx.gCntEndIf {
@@ -1144,15 +1416,20 @@ func walkTemplate(data *ReportData, template Node, ctx *Context, processor Comma
 	}
 
 	return &ReportOutput{
-		Report: out,
-		Images: ctx.images,
-		Links:  ctx.links,
-		Htmls:  ctx.htmls,
+		Report:       out,
+		Images:       ctx.images,
+		Links:        ctx.links,
+		Htmls:        ctx.htmls,
+		Charts:       ctx.charts,
+		ChartUpdates: ctx.chartUpdates,
+		Footnotes:    ctx.footnotes,
+		Comments:     ctx.comments,
+		Numberings:   ctx.numberings,
 	}, retErr
 
 }
 
-func processText(data *ReportData, node *TextNode, ctx *Context, onCommand CommandProcessor) (string, error) {
+func processText(data any, node *TextNode, ctx *Context, onCommand CommandProcessor) (string, error) {
 	cmdDelimiter := ctx.options.CmdDelimiter
 	failFast := ctx.options.FailFast
 
@@ -1166,12 +1443,15 @@ func processText(data *ReportData, node *TextNode, ctx *Context, onCommand Comma
 	errorsList := []error{}
 
 	// When fContinueLoop is set (FOR/END-FOR in the same text node with more items),
-	// we need to re-process the segments from the beginning for each iteration.
+	// we need to re-process the segments following the FOR command for each iteration.
 	// We use a loop that continues as long as fContinueLoop is true.
+	loopStarts := make(map[int]int) // [loop level]index of the segment following the FOR command
+	start := 0
 	for {
 		ctx.fContinueLoop = false
 
-		for idx, segment := range segments {
+		for idx := start; idx < len(segments); idx++ {
+			segment := segments[idx]
 			if idx > 0 {
 				// Include the separators in the `buffers` field (used for deleting paragraphs if appropriate)
 				appendTextToTagBuffers(cmdDelimiter.Open, ctx, map[string]bool{"fCmd": true})
@@ -1189,9 +1469,10 @@ func processText(data *ReportData, node *TextNode, ctx *Context, onCommand Comma
 			// and toggle "command mode"
 			if idx < len(segments)-1 {
 				if ctx.fCmd {
+					numLoops := len(ctx.loops)
 					cmdResultText, err := onCommand(data, node, ctx)
 					if err != nil && err != IgnoreError {
-						if failFast {
+						if failFast || isFatalError(err, ctx) {
 							return "", err
 						} else {
 							errorsList = append(errorsList, err)
@@ -1203,16 +1484,23 @@ func processText(data *ReportData, node *TextNode, ctx *Context, onCommand Comma
 							"fInsertedText": true,
 						})
 					}
+					if len(ctx.loops) > numLoops && getCurLoop(ctx).refNode == node {
+						loopStarts[len(ctx.loops)-1] = idx + 1
+					}
 				}
 				ctx.fCmd = !ctx.fCmd
+				if ctx.fContinueLoop {
+					break
+				}
 			}
 		}
 
 		// If fContinueLoop was set during processing (by processEndForIf for a FOR loop
-		// in the same text node), restart from the beginning to process the next iteration.
+		// in the same text node), restart after the FOR command to process the next iteration.
 		if !ctx.fContinueLoop {
 			break
 		}
+		start = loopStarts[len(ctx.loops)-1]
 	}
 
 	if len(errorsList) > 0 {
@@ -1221,6 +1509,13 @@ func processText(data *ReportData, node *TextNode, ctx *Context, onCommand Comma
 	return outText, nil
 }
 
+// isFatalError reports whether an error must stop the render, even when FailFast is not set
+func isFatalError(err error, ctx *Context) bool {
+	var loopErr *LoopIterationsExceededError
+	var imageErr *ImageBytesExceededError
+	return errors.As(err, &loopErr) || errors.As(err, &imageErr) || ctx.session.Err() != nil
+}
+
 func splitTextByDelimiters(text string, delimiters Delimiters) []string {
 	segments := strings.Split(text, delimiters.Open)
 	var result []string
@@ -1258,21 +1553,14 @@ func appendTextToTagBuffers(text string, ctx *Context, options map[string]bool)
 }
 
 func updateID(newNode *NonTextNode, ctx *Context) {
-	ctx.imageAndShapeIdIncrement += 1
-	id := fmt.Sprint(ctx.imageAndShapeIdIncrement)
+	ctx.session.imageAndShapeIdIncrement += 1
+	id := fmt.Sprint(ctx.session.imageAndShapeIdIncrement)
+	// the attributes are shared with the template node, and with the other copies of it
+	newNode.Attrs = maps.Clone(newNode.Attrs)
 	newNode.Attrs["id"] = id
 }
 
-func NewContext(options CreateReportOptions, imageAndShapeIdIncrement int) Context {
-	builtin := map[string]Function{
-		"len":  length,
-		"join": join,
-	}
-	for k, v := range options.Functions {
-		builtin[k] = v
-	}
-	options.Functions = builtin
-
+func NewContext(session *RenderSession, options CreateReportOptions) Context {
 	return Context{
 		gCntIf:     0,
 		gCntEndIf:  0,
@@ -1285,17 +1573,19 @@ func NewContext(options CreateReportOptions, imageAndShapeIdIncrement int) Conte
 			TR_TAG: {text: "", cmds: "", fInsertedText: false},
 			TC_TAG: {text: "", cmds: "", fInsertedText: false},
 		},
-		imageAndShapeIdIncrement: imageAndShapeIdIncrement,
-		images:                   Images{},
-		linkId:                   0,
-		links:                    Links{},
-		htmlId:                   0,
-		htmls:                    Htmls{},
-		vars:                     map[string]VarValue{},
-		loops:                    []LoopStatus{},
-		fJump:                    false,
-		shorthands:               map[string]string{},
-		options:                  options,
+		images:       Images{},
+		linkId:       0,
+		links:        Links{},
+		htmlId:       0,
+		htmls:        Htmls{},
+		charts:       Charts{},
+		chartUpdates: ChartUpdates{},
+		vars:         map[string]VarValue{},
+		loops:        []LoopStatus{},
+		fJump:        false,
+		shorthands:   map[string]string{},
+		options:      options,
+		session:      session,
 		// To verfiy we don't have a nested if within the same p or tr tag
 		pIfCheckMap:  map[Node]string{},
 		trIfCheckMap: map[Node]string{},
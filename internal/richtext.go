@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// RichText is a text made of spans with their own formatting, inserted by INS as one run per span
+type RichText []TextSpan
+
+// TextSpan is a part of a RichText. The formatting of the command run is kept,
+// except for the properties set on the span.
+type TextSpan struct {
+	Text      string
+	Bold      bool
+	Italic    bool
+	Underline bool
+	Strike    bool
+	Color     string  // optional, hex RGB e.g. "FF0000"
+	Font      string  // optional
+	Size      float64 // optional, in points
+}
+
+// rPrOrder is the order of the children of a w:rPr required by the schema
+var rPrOrder = []string{
+	"w:rStyle", "w:rFonts", "w:b", "w:bCs", "w:i", "w:iCs", "w:caps", "w:smallCaps", "w:strike", "w:dstrike",
+	"w:outline", "w:shadow", "w:emboss", "w:imprint", "w:noProof", "w:snapToGrid", "w:vanish", "w:webHidden",
+	"w:color", "w:spacing", "w:w", "w:kern", "w:position", "w:sz", "w:szCs", "w:highlight", "w:u", "w:effect",
+	"w:bdr", "w:shd", "w:fitText", "w:vertAlign", "w:rtl", "w:cs", "w:em", "w:lang", "w:eastAsianLayout",
+	"w:specVanish", "w:oMath",
+}
+
+// spanRunProps returns the run properties of a span: those of the command run,
+// overridden by the ones set on the span
+func spanRunProps(runProps *NonTextNode, span TextSpan) *NonTextNode {
+	node := NewNonTextNode
+	overrides := []*NonTextNode{}
+	if span.Font != "" {
+		overrides = append(overrides, node("w:rFonts", map[string]string{"w:ascii": span.Font, "w:hAnsi": span.Font, "w:cs": span.Font}, nil))
+	}
+	if span.Bold {
+		overrides = append(overrides, node("w:b", nil, nil))
+	}
+	if span.Italic {
+		overrides = append(overrides, node("w:i", nil, nil))
+	}
+	if span.Strike {
+		overrides = append(overrides, node("w:strike", nil, nil))
+	}
+	if span.Color != "" {
+		overrides = append(overrides, node("w:color", map[string]string{"w:val": strings.TrimPrefix(span.Color, "#")}, nil))
+	}
+	if span.Size > 0 {
+		halfPoints := fmt.Sprint(int(span.Size*2 + 0.5))
+		overrides = append(overrides,
+			node("w:sz", map[string]string{"w:val": halfPoints}, nil),
+			node("w:szCs", map[string]string{"w:val": halfPoints}, nil),
+		)
+	}
+	if span.Underline {
+		overrides = append(overrides, node("w:u", map[string]string{"w:val": "single"}, nil))
+	}
+
+	children := []Node{}
+	if runProps != nil {
+		for _, child := range runProps.Children() {
+			prop, ok := child.(*NonTextNode)
+			if ok && slices.ContainsFunc(overrides, func(override *NonTextNode) bool { return override.Tag == prop.Tag }) {
+				continue
+			}
+			children = append(children, CloneNode(child))
+		}
+	}
+	for _, override := range overrides {
+		children = append(children, override)
+	}
+	// keep the order of the schema, with the unknown properties last
+	rank := func(child Node) int {
+		if prop, ok := child.(*NonTextNode); ok {
+			if index := slices.Index(rPrOrder, prop.Tag); index != -1 {
+				return index
+			}
+		}
+		return len(rPrOrder)
+	}
+	slices.SortStableFunc(children, func(a, b Node) int { return rank(a) - rank(b) })
+	return node(RPR_TAG, nil, children)
+}
+
+// processRichText returns the literal XML of the runs of a RichText,
+// splitting the current run around them
+func processRichText(ctx *Context, richText RichText) string {
+	literalXmlDelimiter := ctx.options.LiteralXmlDelimiter
+	xmlOptions := XmlOptions{LiteralXmlDelimiter: literalXmlDelimiter}
+	var runs strings.Builder
+	for _, span := range richText {
+		runs.WriteString(`<w:r>`)
+		runs.Write(BuildXml(spanRunProps(ctx.textRunPropsNode, span), xmlOptions, " "))
+		lines := []string{span.Text}
+		if ctx.options.ProcessLineBreaks {
+			lines = strings.Split(span.Text, "\n")
+		}
+		for i, line := range lines {
+			if i > 0 {
+				runs.WriteString(`<w:br/>`)
+			}
+			// the text is escaped here, as it must not contain literal XML
+			runs.WriteString(`<w:t xml:space="preserve">` + sanitizeAttr(line) + `</w:t>`)
+		}
+		runs.WriteString(`</w:r>`)
+	}
+
+	runProps := ""
+	if ctx.textRunPropsNode != nil {
+		runProps = string(BuildXml(ctx.textRunPropsNode, xmlOptions, " "))
+	}
+	return literalXmlDelimiter +
+		`</w:t></w:r>` + escapeLiteralXmlDelimiter(runs.String(), literalXmlDelimiter) +
+		`<w:r>` + runProps + `<w:t xml:space="preserve">` +
+		literalXmlDelimiter
+}
+
+// escapeLiteralXmlDelimiter replaces the literal XML delimiter in generated XML by character references,
+// so that the text of the spans can't be interpreted as literal XML
+func escapeLiteralXmlDelimiter(xml string, literalXmlDelimiter string) string {
+	if literalXmlDelimiter == "" {
+		return xml
+	}
+	var escaped strings.Builder
+	for _, r := range literalXmlDelimiter {
+		escaped.WriteString(fmt.Sprintf("&#%d;", r))
+	}
+	return strings.ReplaceAll(xml, literalXmlDelimiter, escaped.String())
+}
+
+// escapedTextNode returns a text node for a text from the data, as literal XML with the delimiter escaped,
+// so that it can't be interpreted as literal XML once serialized
+func escapedTextNode(text string, literalXmlDelimiter string) *TextNode {
+	if literalXmlDelimiter == "" || !strings.Contains(text, literalXmlDelimiter) {
+		return NewTextNode(text)
+	}
+	return NewTextNode(literalXmlDelimiter + escapeLiteralXmlDelimiter(sanitizeAttr(text), literalXmlDelimiter) + literalXmlDelimiter)
+}
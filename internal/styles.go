@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Styles are the styles defined in the template, by id, with their name
+type Styles map[string]string // [styleId]name
+
+func readStyles(part *NonTextNode) Styles {
+	styles := Styles{}
+	for _, child := range part.Children() {
+		style, ok := child.(*NonTextNode)
+		if !ok || style.Tag != "w:style" {
+			continue
+		}
+		name := ""
+		for _, prop := range style.Children() {
+			if propNode, ok := prop.(*NonTextNode); ok && propNode.Tag == "w:name" {
+				name = propNode.Attrs["w:val"]
+			}
+		}
+		styles[style.Attrs["w:styleId"]] = name
+	}
+	return styles
+}
+
+// styleId returns the id of a style of the template given by id or by name
+func (s Styles) styleId(style string) (string, error) {
+	if _, ok := s[style]; ok {
+		return style, nil
+	}
+	if id := s.IdByName(style, ""); id != "" {
+		return id, nil
+	}
+	return "", &StyleNotFoundError{Style: style}
+}
+
+// setStyle sets the style (w:pStyle or w:rStyle) of a paragraph or run, as the first of its properties
+func setStyle(parent *NonTextNode, propsTag string, styleTag string, styleId string) {
+	var props *NonTextNode
+	if children := parent.Children(); len(children) > 0 {
+		if first, ok := children[0].(*NonTextNode); ok && first.Tag == propsTag {
+			props = first
+		}
+	}
+	if props == nil {
+		props = NewNonTextNode(propsTag, nil, nil)
+		props.SetParent(parent)
+		parent.SetChildren(append([]Node{props}, parent.Children()...))
+	}
+	style := NewNonTextNode(styleTag, map[string]string{"w:val": styleId}, nil)
+	style.SetParent(props)
+	children := slices.DeleteFunc(slices.Clone(props.Children()), func(child Node) bool {
+		prop, ok := child.(*NonTextNode)
+		return ok && prop.Tag == styleTag
+	})
+	props.SetChildren(append([]Node{style}, children...))
+}
+
+// IdByName returns the id of the style with the given name, ignoring case,
+// or fallback if the template has no such style. When several styles have the
+// name, the lowest id is returned, so that every render picks the same one.
+func (s Styles) IdByName(name string, fallback string) string {
+	for _, id := range slices.Sorted(maps.Keys(s)) {
+		styleName := s[id]
+		if strings.EqualFold(styleName, name) {
+			return id
+		}
+	}
+	return fallback
+}
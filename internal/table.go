@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TablePars is the value of a TABLE command
+type TablePars struct {
+	Header       []any     // optional
+	Rows         [][]any   // cells are a TableCell, a RichText, or any value inserted as text
+	ColumnWidths []float32 // optional, in cm
+	ColumnAligns []string  // optional, "left", "center", "right" or "justify" for each column
+	Style        string    // optional, id or name of a table style of the template
+	RepeatHeader bool      // repeat the header row at the top of each page
+}
+
+// TableCell is a cell of a TABLE with its own alignment
+type TableCell struct {
+	Value any
+	Align string // optional, overrides the alignment of the column
+}
+
+// tableParsFrom converts the result of a TABLE expression
+func tableParsFrom(varValue VarValue) (*TablePars, bool) {
+	switch value := varValue.(type) {
+	case *TablePars:
+		return value, value != nil
+	case TablePars:
+		return &value, true
+	}
+	return nil, false
+}
+
+// processTable builds the table of a TABLE command, replacing the paragraph of the command
+func processTable(ctx *Context, tablePars *TablePars) error {
+	node := NewNonTextNode
+	columns := len(tablePars.Header)
+	for _, row := range tablePars.Rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		ctx.pendingHtmlNodes = []Node{}
+		return nil
+	}
+
+	// widths in twips, automatic when not given; the columns without a width
+	// share the rest of the page width
+	widths := make([]int, columns)
+	fixedWidths := len(tablePars.ColumnWidths) > 0
+	givenWidths := min(len(tablePars.ColumnWidths), columns)
+	pageWidth := pageContentTwips(ctx)
+	restWidth := pageWidth
+	for i := range givenWidths {
+		widths[i] = int(tablePars.ColumnWidths[i] * 1440 / 2.54)
+		restWidth -= widths[i]
+	}
+	if givenWidths < columns {
+		sharedWidth := restWidth / (columns - givenWidths)
+		if sharedWidth <= 0 {
+			sharedWidth = pageWidth / columns
+		}
+		for i := givenWidths; i < columns; i++ {
+			widths[i] = sharedWidth
+		}
+	}
+
+	tableProps := []Node{}
+	if tablePars.Style != "" {
+		styleId, err := ctx.session.styles.styleId(tablePars.Style)
+		if err != nil {
+			return err
+		}
+		tableProps = append(tableProps, node("w:tblStyle", map[string]string{"w:val": styleId}, nil))
+	} else if styleId := ctx.session.styles.IdByName("table grid", ""); styleId != "" {
+		tableProps = append(tableProps, node("w:tblStyle", map[string]string{"w:val": styleId}, nil))
+	}
+	if fixedWidths {
+		total := 0
+		for _, width := range widths {
+			total += width
+		}
+		tableProps = append(tableProps, node("w:tblW", map[string]string{"w:w": strconv.Itoa(total), "w:type": "dxa"}, nil))
+	} else {
+		tableProps = append(tableProps, node("w:tblW", map[string]string{"w:w": "5000", "w:type": "pct"}, nil))
+	}
+	if tablePars.Style == "" {
+		borders := []Node{}
+		for _, side := range []string{"w:top", "w:left", "w:bottom", "w:right", "w:insideH", "w:insideV"} {
+			borders = append(borders, node(side, map[string]string{"w:val": "single", "w:sz": "4", "w:space": "0", "w:color": "auto"}, nil))
+		}
+		tableProps = append(tableProps, node("w:tblBorders", nil, borders))
+	}
+	if fixedWidths {
+		tableProps = append(tableProps, node("w:tblLayout", map[string]string{"w:type": "fixed"}, nil))
+	}
+	firstRow := "0"
+	if len(tablePars.Header) > 0 {
+		firstRow = "1"
+	}
+	tableProps = append(tableProps, node("w:tblLook", map[string]string{"w:firstRow": firstRow, "w:noVBand": "1"}, nil))
+
+	grid := []Node{}
+	for _, width := range widths {
+		grid = append(grid, node("w:gridCol", map[string]string{"w:w": strconv.Itoa(width)}, nil))
+	}
+	children := []Node{node("w:tblPr", nil, tableProps), node("w:tblGrid", nil, grid)}
+
+	if len(tablePars.Header) > 0 {
+		rowProps := []Node{}
+		if tablePars.RepeatHeader {
+			rowProps = append(rowProps, node("w:trPr", nil, []Node{node("w:tblHeader", nil, nil)}))
+		}
+		// without a table style, the header is in bold
+		children = append(children, tableRow(tablePars, widths, tablePars.Header, rowProps, tablePars.Style == "", ctx.options.LiteralXmlDelimiter))
+	}
+	for _, row := range tablePars.Rows {
+		children = append(children, tableRow(tablePars, widths, row, nil, false, ctx.options.LiteralXmlDelimiter))
+	}
+	ctx.pendingHtmlNodes = []Node{node(TBL_TAG, nil, children)}
+	return nil
+}
+
+// pageContentTwips returns the width between the margins of the pages of the template, in twips,
+// or the one of an A4 page with 2 cm margins if the template has no page size
+func pageContentTwips(ctx *Context) int {
+	if width := ctx.session.pageContentWidth; width > 0 {
+		return int(math.Round(float64(width) * 1440 / 2.54))
+	}
+	return 9638
+}
+
+// tableRow builds a row of a TABLE, completed with empty cells up to the number of columns
+func tableRow(tablePars *TablePars, widths []int, cells []any, rowProps []Node, bold bool, literalXmlDelimiter string) *NonTextNode {
+	node := NewNonTextNode
+	rowChildren := rowProps
+	for i, width := range widths {
+		var value any
+		if i < len(cells) {
+			value = cells[i]
+		}
+		align := ""
+		if i < len(tablePars.ColumnAligns) {
+			align = tablePars.ColumnAligns[i]
+		}
+		switch cell := value.(type) {
+		case TableCell:
+			value = cell.Value
+			if cell.Align != "" {
+				align = cell.Align
+			}
+		case *TableCell:
+			if cell != nil {
+				value = cell.Value
+				if cell.Align != "" {
+					align = cell.Align
+				}
+			}
+		}
+
+		cellWidth := map[string]string{"w:w": "0", "w:type": "auto"}
+		if len(tablePars.ColumnWidths) > 0 {
+			cellWidth = map[string]string{"w:w": strconv.Itoa(width), "w:type": "dxa"}
+		}
+		paragraph := []Node{}
+		if align := htmlAlign(align); align != "" {
+			paragraph = append(paragraph, node("w:pPr", nil, []Node{node("w:jc", map[string]string{"w:val": align}, nil)}))
+		}
+		paragraph = append(paragraph, tableCellRuns(value, bold, literalXmlDelimiter)...)
+		rowChildren = append(rowChildren, node(TC_TAG, nil, []Node{
+			node("w:tcPr", nil, []Node{node("w:tcW", cellWidth, nil)}),
+			node(P_TAG, nil, paragraph),
+		}))
+	}
+	return node(TR_TAG, nil, rowChildren)
+}
+
+// tableCellRuns returns the runs of the value of a cell, with a line break for each "\n"
+func tableCellRuns(value any, bold bool, literalXmlDelimiter string) []Node {
+	node := NewNonTextNode
+	spans := RichText{{Text: toString(value)}}
+	switch richText := value.(type) {
+	case RichText:
+		spans = richText
+	case *RichText:
+		if richText != nil {
+			spans = *richText
+		}
+	}
+	runs := []Node{}
+	for _, span := range spans {
+		span.Bold = span.Bold || bold
+		content := []Node{}
+		if runProps := spanRunProps(nil, span); len(runProps.Children()) > 0 {
+			content = append(content, runProps)
+		}
+		for i, line := range strings.Split(span.Text, "\n") {
+			if i > 0 {
+				content = append(content, node("w:br", nil, nil))
+			}
+			content = append(content, node(T_TAG, map[string]string{"xml:space": "preserve"}, []Node{escapedTextNode(line, literalXmlDelimiter)}))
+		}
+		runs = append(runs, node(R_TAG, nil, content))
+	}
+	return runs
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"reflect"
 )
 
@@ -17,6 +18,9 @@ const (
 	ALTCHUNK_TAG = "w:altChunk"
 )
 
+// PARAGRAPH_CONTAINER_TAGS are the nodes which must keep at least one paragraph
+var PARAGRAPH_CONTAINER_TAGS = []string{TC_TAG, "w:footnote", "w:endnote", "w:comment", "w:hdr", "w:ftr"}
+
 type Node interface {
 	Parent() Node
 	SetParent(Node)
@@ -105,6 +109,7 @@ type BufferStatus struct {
 	text          string
 	cmds          string
 	fInsertedText bool
+	fKeep         bool // holds the output of an IF branch, and must be kept while skipping the next branches
 }
 
 type Context struct {
@@ -120,20 +125,30 @@ type Context struct {
 		image   *NonTextNode
 		caption []*NonTextNode
 	}
-	imageAndShapeIdIncrement int
-	images                   Images
-	pendingLinkNode          *NonTextNode
-	linkId                   int
-	links                    Links
-	pendingHtmlNode          Node
-	htmlId                   int
-	htmls                    Htmls
-	vars                     map[string]VarValue
-	loops                    []LoopStatus
-	fJump                    bool
-	fContinueLoop            bool
-	shorthands               map[string]string
-	options                  CreateReportOptions
+	images           Images
+	pendingLinkNode  *NonTextNode
+	linkId           int
+	links            Links
+	pendingHtmlNodes []Node
+	htmlId           int
+	htmls            Htmls
+	charts           Charts
+	pendingChartData *ChartData        // data bound to the next chart of the template
+	pendingImage     *placeholderImage // image replacing the current placeholder image of the template
+	chartUpdates     ChartUpdates
+	footnotes        []Note
+	pendingComments  []Note // comments of the current paragraph
+	comments         []Note
+	numberings       []Numbering
+	paragraphStyle   string // style of the current paragraph, set by STYLE
+	runStyle         string // style of the current run, set by CSTYLE
+	vars             map[string]VarValue
+	loops            []LoopStatus
+	fJump            bool
+	fContinueLoop    bool
+	shorthands       map[string]string
+	options          CreateReportOptions
+	session          *RenderSession
 	//jsSandbox                SandBox
 	textRunPropsNode *NonTextNode
 
@@ -163,10 +178,80 @@ type CreateReportOptions struct {
 	ErrorHandler               ErrorHandler
 	FixSmartQuotes             bool
 	ProcessLineBreaksAsNewText bool
-	MaximumWalkingDepth        int
+	MaximumWalkingDepth        int   // maximum number of steps when walking a document, defaults to 1 000 000
+	MaximumLoopIterations      int   // maximum number of FOR loop iterations in a render, 0 means unlimited
+	MaximumOutputSize          int64 // maximum uncompressed size of the generated document in bytes, 0 means unlimited
+	MaximumImageBytes          int64 // maximum total size of the inserted images in bytes, 0 means unlimited
+	ConvertHtml                bool  // convert the content of HTML commands to Word paragraphs, tables and lists, instead of embedding it with an altChunk
+	BindPlaceholderImages      bool  // replace the pictures whose alt text or title is a command, e.g. +++logo+++, by the image of the command
 	Functions                  Functions
 }
 
+// RenderSession holds the state shared by all the parts (main document,
+// headers, footers, notes...) of a single render: cancellation, resource usage,
+// and the image and shape id counter, so that ids are unique across parts.
+type RenderSession struct {
+	runCtx                   context.Context
+	done                     <-chan struct{}
+	loopIterations           int
+	imageBytes               int64
+	imageAndShapeIdIncrement int
+	footnoteId               int
+	commentId                int
+	numId                    int
+	styles                   Styles
+	pageContentWidth         float32 // in cm, 0 if unknown
+	parseCache               *ParseCache
+}
+
+func NewRenderSession(runCtx context.Context, imageAndShapeIdIncrement int) *RenderSession {
+	return &RenderSession{
+		runCtx:                   runCtx,
+		done:                     runCtx.Done(),
+		imageAndShapeIdIncrement: imageAndShapeIdIncrement,
+		parseCache:               NewParseCache(),
+	}
+}
+
+// SetNoteIds sets the last footnote and comment ids already used by the template,
+// the notes added by the render get the next ones.
+func (s *RenderSession) SetNoteIds(footnoteId, commentId int) {
+	s.footnoteId = footnoteId
+	s.commentId = commentId
+}
+
+// SetNumId sets the last list numbering id already used by the template.
+func (s *RenderSession) SetNumId(numId int) {
+	s.numId = numId
+}
+
+// SetParseCache sets the cache of the parsed expressions of the template,
+// shared by its renders instead of a cache of the render only.
+func (s *RenderSession) SetParseCache(cache *ParseCache) {
+	s.parseCache = cache
+}
+
+// SetStyles sets the styles of the template.
+func (s *RenderSession) SetStyles(styles Styles) {
+	s.styles = styles
+}
+
+// SetPageContentWidth sets the width between the margins of the pages of the template, in cm,
+// which is the default maximum width of the images.
+func (s *RenderSession) SetPageContentWidth(width float32) {
+	s.pageContentWidth = width
+}
+
+// Err returns the cancellation error of the render, if any.
+func (s *RenderSession) Err() error {
+	select {
+	case <-s.done:
+		return s.runCtx.Err()
+	default:
+		return nil
+	}
+}
+
 type VarValue = any
 
 type Image struct {
@@ -191,12 +276,29 @@ type Thumbnail struct {
 type ImagePars struct {
 	Extension string // [".png", ".gif", ".jpg", ".jpeg", ".svg"]
 	Data      []byte
-	Width     float32
-	Height    float32
-	Thumbnail *Thumbnail // optional
-	Alt       string     // optional
-	Rotation  int        // optional
-	Caption   string     // optional
+	Width     float32      // optional, in cm, computed from the image and Height if not given
+	Height    float32      // optional, in cm, computed from the image and Width if not given
+	MaxWidth  float32      // optional, in cm, the width of the page content by default if Width isn't given
+	MaxHeight float32      // optional, in cm
+	Thumbnail *Thumbnail   // optional
+	Alt       string       // optional
+	Rotation  int          // optional
+	Caption   string       // optional
+	Fit       bool         // optional, placeholder images: fit the frame to the aspect ratio of the image
+	Anchor    *ImageAnchor // optional, floats the image instead of inserting it in the text
+}
+
+// MapEntry is a key/value pair yielded by a FOR loop over a map or an iter.Seq2.
+type MapEntry struct {
+	Key   any
+	Value any
+}
+
+// Group is a group of items yielded by a FOR loop with a GROUP BY clause, or by groupBy.
+type Group struct {
+	Key   any
+	Items []any
+	Count int
 }
 
 type LoopStatus struct {
@@ -206,6 +308,8 @@ type LoopStatus struct {
 	loopOver     []VarValue
 	idx          int
 	isIf         bool
+	branchTaken  bool // IF only: a branch (IF, ELSE-IF or ELSE) has already run
+	elseSeen     bool // IF only: the ELSE branch has started
 }
 
 type LinkPars struct {
@@ -4,45 +4,366 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"reflect"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
 )
 
-func getValueFrom(key string, source map[string]any) (VarValue, bool) {
-
-	splitted := strings.Split(key, ".")
-	idx := 0
-	var loopMap map[string]any = source
-	for idx < len(splitted) {
-		optional := false
-		key := splitted[idx]
-		if strings.HasSuffix(key, "?") {
-			optional = true
-			key = strings.TrimSuffix(key, "?")
-		}
-		value, ok := loopMap[key]
-
-		// Last iteration
-		if ok && idx == len(splitted)-1 {
-			return value, true
-			// Ok, but not last iteration
-		} else if ok {
-			loopMap, ok = value.(map[string]any)
+// getValueFrom resolves a path (e.g. `person.address?.city`, `people[0].name`,
+// `totals['2024']`, `items[-1]`, `items[1:3]`) in source.
+// Each segment can be a key of a map, an exported field of a struct (see getField),
+// or an index or slice of a slice, array or string (see getIndex and getSlice).
+// A segment suffixed with `?` is optional: when it is missing or null,
+// the whole path resolves to an empty string.
+func getValueFrom(key string, source any) (VarValue, bool) {
+	value, ok, _ := resolvePath(key, source)
+	return value, ok
+}
+
+// resolvePath is getValueFrom, also telling whether the path resolved to an empty string
+// because of a missing optional segment
+func resolvePath(key string, source any) (value VarValue, ok bool, missingOptional bool) {
+	segments, ok := splitPath(key)
+	if !ok {
+		return "", false, false
+	}
+	return resolveSegments(segments, source)
+}
+
+// resolveSegments is resolvePath, with the path already split into segments
+func resolveSegments(segments []pathSegment, source any) (value VarValue, ok bool, missingOptional bool) {
+	value = source
+	previousOptional := false
+	for _, segment := range segments {
+		var next VarValue
+		switch {
+		case segment.isSlice:
+			next, ok = getSlice(value, segment.sliceStart, segment.sliceEnd)
+		case segment.isIndex:
+			next, ok = getIndex(value, segment.index)
+		default:
+			next, ok = getField(value, segment.key)
+		}
+		if !ok {
+			optional := segment.optional || previousOptional
+			return "", optional, optional
+		}
+		value = next
+		previousOptional = segment.optional
+	}
+	return value, true, false
+}
+
+type pathSegment struct {
+	key        string
+	index      int
+	isIndex    bool
+	sliceStart *int
+	sliceEnd   *int
+	isSlice    bool
+	optional   bool
+}
+
+// splitPath splits a path into its segments
+func splitPath(path string) ([]pathSegment, bool) {
+	segments := []pathSegment{}
+	i := 0
+	for i < len(path) {
+		switch path[i] {
+		case '.':
+			i++
+		case '?':
+			if len(segments) == 0 {
+				return nil, false
+			}
+			segments[len(segments)-1].optional = true
+			i++
+		case '[':
+			end := findClosingBracket(path, i)
+			if end < 0 {
+				return nil, false
+			}
+			segment, ok := parseBracket(strings.TrimSpace(path[i+1 : end]))
 			if !ok {
-				return "", optional
+				return nil, false
+			}
+			segments = append(segments, segment)
+			i = end + 1
+		default:
+			end := strings.IndexAny(path[i:], ".?[")
+			if end < 0 {
+				end = len(path)
+			} else {
+				end += i
+			}
+			segments = append(segments, pathSegment{key: path[i:end]})
+			i = end
+		}
+	}
+	return segments, true
+}
+
+// findClosingBracket finds the `]` matching the `[` at path[start], skipping quoted strings
+func findClosingBracket(path string, start int) int {
+	var quote byte
+	for i := start + 1; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseBracket parses the content of a bracket: `'key'`, `"key"`, `2`, `-1` or `start:end`
+func parseBracket(content string) (pathSegment, bool) {
+	if len(content) >= 2 && (content[0] == '\'' || content[0] == '"') && content[len(content)-1] == content[0] {
+		return pathSegment{key: content[1 : len(content)-1]}, true
+	}
+	if startText, endText, isSlice := strings.Cut(content, ":"); isSlice {
+		segment := pathSegment{isSlice: true}
+		for _, bound := range []struct {
+			text  string
+			value **int
+		}{{startText, &segment.sliceStart}, {endText, &segment.sliceEnd}} {
+			text := strings.TrimSpace(bound.text)
+			if text == "" {
+				continue
+			}
+			value, err := strconv.Atoi(text)
+			if err != nil {
+				return segment, false
+			}
+			*bound.value = &value
+		}
+		return segment, true
+	}
+	index, err := strconv.Atoi(content)
+	if err != nil {
+		return pathSegment{}, false
+	}
+	return pathSegment{key: content, index: index, isIndex: true}, true
+}
+
+// getIndex gets an element of a slice, an array or a string (negative indexes count from the end),
+// or the value of a map with integer or string keys
+func getIndex(value any, index int) (VarValue, bool) {
+	reflected := reflect.ValueOf(value)
+	for reflected.Kind() == reflect.Pointer || reflected.Kind() == reflect.Interface {
+		if reflected.IsNil() {
+			return nil, false
+		}
+		reflected = reflected.Elem()
+	}
+
+	switch reflected.Kind() {
+	case reflect.String:
+		// strings are indexed by characters, not bytes
+		runes := []rune(reflected.String())
+		if index < 0 {
+			index += len(runes)
+		}
+		if index < 0 || index >= len(runes) {
+			return nil, false
+		}
+		return string(runes[index]), true
+	case reflect.Slice, reflect.Array:
+		length := reflected.Len()
+		if index < 0 {
+			index += length
+		}
+		if index < 0 || index >= length {
+			return nil, false
+		}
+		return reflected.Index(index).Interface(), true
+	case reflect.Map:
+		keyType := reflected.Type().Key()
+		var key reflect.Value
+		switch keyType.Kind() {
+		case reflect.String:
+			key = reflect.ValueOf(strconv.Itoa(index)).Convert(keyType)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			key = reflect.ValueOf(int64(index)).Convert(keyType)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if index < 0 {
+				return nil, false
+			}
+			key = reflect.ValueOf(uint64(index)).Convert(keyType)
+		default:
+			return nil, false
+		}
+		element := reflected.MapIndex(key)
+		if !element.IsValid() {
+			return nil, false
+		}
+		return element.Interface(), true
+	}
+	return nil, false
+}
+
+// getSlice gets a part of a slice, an array or a string (by characters). Negative bounds count from the end,
+// and bounds out of range are clamped.
+func getSlice(value any, start *int, end *int) (VarValue, bool) {
+	reflected := reflect.ValueOf(value)
+	for reflected.Kind() == reflect.Pointer || reflected.Kind() == reflect.Interface {
+		if reflected.IsNil() {
+			return nil, false
+		}
+		reflected = reflected.Elem()
+	}
+
+	switch reflected.Kind() {
+	case reflect.Slice, reflect.Array, reflect.String:
+	default:
+		return nil, false
+	}
+
+	var runes []rune
+	length := reflected.Len()
+	if reflected.Kind() == reflect.String {
+		runes = []rune(reflected.String())
+		length = len(runes)
+	}
+	bound := func(b *int, defaultValue int) int {
+		if b == nil {
+			return defaultValue
+		}
+		value := *b
+		if value < 0 {
+			value += length
+		}
+		return max(0, min(value, length))
+	}
+	from := bound(start, 0)
+	to := max(from, bound(end, length))
+
+	if reflected.Kind() == reflect.String {
+		return string(runes[from:to]), true
+	}
+	if reflected.Kind() == reflect.Array {
+		// arrays obtained from an interface are not addressable, copy them in a slice
+		copied := reflect.MakeSlice(reflect.SliceOf(reflected.Type().Elem()), length, length)
+		reflect.Copy(copied, reflected)
+		reflected = copied
+	}
+	return reflected.Slice(from, to).Interface(), true
+}
+
+// getField gets the value of a key of a map with string keys, or of a struct field
+// named by its `docx:"name"` tag or by its name (compared case-insensitively if
+// there is no exact match). Pointers and interfaces are dereferenced.
+func getField(value any, name string) (VarValue, bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, false
+	case map[string]any:
+		field, ok := v[name]
+		return field, ok
+	case ReportData:
+		field, ok := v[name]
+		return field, ok
+	}
+
+	reflected := reflect.ValueOf(value)
+	for reflected.Kind() == reflect.Pointer || reflected.Kind() == reflect.Interface {
+		if reflected.IsNil() {
+			return nil, false
+		}
+		reflected = reflected.Elem()
+	}
+
+	switch reflected.Kind() {
+	case reflect.Slice, reflect.Array:
+		if index, err := strconv.Atoi(name); err == nil {
+			return getIndex(value, index)
+		}
+	case reflect.Map:
+		keyType := reflected.Type().Key()
+		if keyType.Kind() != reflect.String {
+			if index, err := strconv.Atoi(name); err == nil {
+				return getIndex(value, index)
 			}
-			// Not ok but optional
-		} else if optional {
-			return "", true
-			// Not ok at all
-		} else {
-			return "", false
+			return nil, false
+		}
+		field := reflected.MapIndex(reflect.ValueOf(name).Convert(keyType))
+		if !field.IsValid() {
+			return nil, false
+		}
+		return field.Interface(), true
+	case reflect.Struct:
+		index, ok := getStructFields(reflected.Type()).lookup(name)
+		if !ok {
+			return nil, false
 		}
+		field, err := reflected.FieldByIndexErr(index)
+		if err != nil { // nil embedded pointer
+			return nil, false
+		}
+		return field.Interface(), true
+	}
+	return nil, false
+}
 
-		idx++
+type structFields struct {
+	byName      map[string][]int
+	byLowerName map[string][]int
+}
+
+func (f *structFields) lookup(name string) ([]int, bool) {
+	if index, ok := f.byName[name]; ok {
+		return index, true
 	}
-	return "", false
+	index, ok := f.byLowerName[strings.ToLower(name)]
+	return index, ok
+}
 
+// structFieldsCache caches the accessible fields of struct types
+var structFieldsCache sync.Map // [reflect.Type]*structFields
+
+func getStructFields(structType reflect.Type) *structFields {
+	if cached, ok := structFieldsCache.Load(structType); ok {
+		return cached.(*structFields)
+	}
+	fields := &structFields{
+		byName:      map[string][]int{},
+		byLowerName: map[string][]int{},
+	}
+	// VisibleFields includes the fields promoted from embedded structs
+	for _, field := range reflect.VisibleFields(structType) {
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("docx"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		// Shallower fields win over the ones promoted from embedded structs
+		if existing, ok := fields.byName[name]; !ok || len(field.Index) < len(existing) {
+			fields.byName[name] = field.Index
+		}
+		lowerName := strings.ToLower(name)
+		if existing, ok := fields.byLowerName[lowerName]; !ok || len(field.Index) < len(existing) {
+			fields.byLowerName[lowerName] = field.Index
+		}
+	}
+	structFieldsCache.Store(structType, fields)
+	return fields
 }
 
 func AddChild(parent Node, child Node) Node {
@@ -70,6 +391,37 @@ func CloneNodeWithoutChildren(node Node) Node {
 	return nil
 }
 
+// CloneNode creates a deep copy of a node and all of its descendants.
+// Attributes are copied too, so the clone can be mutated freely.
+func CloneNode(node Node) Node {
+	var clone Node
+	switch nd := node.(type) {
+	case *NonTextNode:
+		clone = &NonTextNode{
+			BaseNode: BaseNode{NodeName: nd.NodeName},
+			Tag:      nd.Tag,
+			Attrs:    maps.Clone(nd.Attrs),
+		}
+	case *TextNode:
+		clone = &TextNode{
+			BaseNode: BaseNode{NodeName: nd.NodeName},
+			Text:     nd.Text,
+		}
+	default:
+		return nil
+	}
+	children := node.Children()
+	if len(children) > 0 {
+		clonedChildren := make([]Node, len(children))
+		for i, child := range children {
+			clonedChildren[i] = CloneNode(child)
+			clonedChildren[i].SetParent(clone)
+		}
+		clone.SetChildren(clonedChildren)
+	}
+	return clone
+}
+
 // InsertTextSiblingAfter crée et insère un nouveau noeud texte après le noeud texte donné
 // Retourne le nouveau noeud texte ou une erreur si les conditions ne sont pas remplies
 func InsertTextSiblingAfter(textNode *TextNode) (*TextNode, error) {
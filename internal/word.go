@@ -3,7 +3,9 @@ package internal
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"path"
 	"slices"
 	"strings"
 )
@@ -12,14 +14,19 @@ const (
 	TEMPLATE_PATH                 = "word"
 	CONTENT_TYPES_PATH            = "[Content_Types].xml"
 	DEFAULT_LITERAL_XML_DELIMITER = "||"
+	DEFAULT_MAXIMUM_WALKING_DEPTH = 1_000_000
 )
 
 type ParseTemplateResult struct {
-	Root         Node
-	MainDocument string
-	Zip          *ZipArchive
-	ContentTypes *NonTextNode
-	Extras       map[string]Node // [path]Node
+	Root          Node
+	MainDocument  string
+	Zip           *ZipArchive
+	ContentTypes  *NonTextNode
+	Extras        map[string]Node   // [path]Node
+	ExtraKinds    map[string]string // [path]kind, see EXTRA_PART_KINDS
+	NumberingPath string            // empty if the template has no numbering part
+	Numbering     *NonTextNode
+	Styles        Styles
 }
 
 func ProcessImages(images Images, documentComponent string, zip *ZipArchive) error {
@@ -36,8 +43,7 @@ func ProcessImages(images Images, documentComponent string, zip *ZipArchive) err
 		return nil
 	}
 	slog.Debug("Completing document.xml.rels...")
-	//relsPath = `${TEMPLATE_PATH}/_rels/${documentComponent}.rels`;
-	relsPath := fmt.Sprintf("%s/_rels/%s.rels", TEMPLATE_PATH, documentComponent)
+	relsPath := partRelsPath(documentComponent)
 	rels, err := getRelsFromZip(zip, relsPath)
 	if err != nil {
 		return err
@@ -49,7 +55,7 @@ func ProcessImages(images Images, documentComponent string, zip *ZipArchive) err
 		imgData := image.Data
 
 		// `template_${documentComponent}_${imageId}${extension}`;
-		imgName := fmt.Sprintf("template_%s_%s%s", documentComponent, imageId, extension)
+		imgName := fmt.Sprintf("template_%s_%s%s", strings.ReplaceAll(documentComponent, "/", "_"), imageId, extension)
 		// logger.debug(`Writing image ${imageId} (${imgName})...`);
 		slog.Debug("Writing image " + imageId + " (" + imgName + ")...")
 		imgPath := fmt.Sprintf("%s/media/%s", TEMPLATE_PATH, imgName)
@@ -58,7 +64,7 @@ func ProcessImages(images Images, documentComponent string, zip *ZipArchive) err
 		AddChild(rels, NewNonTextNode("Relationship", map[string]string{
 			"Id":     imageId,
 			"Type":   "http://schemas.openxmlformats.org/officeDocument/2006/relationships/image",
-			"Target": fmt.Sprintf("media/%s", imgName),
+			"Target": partTarget(documentComponent, "media/"+imgName),
 		}, nil))
 	}
 	finalRelsXml := BuildXml(rels, XmlOptions{
@@ -76,7 +82,7 @@ func ProcessHtmls(htmls Htmls, documentComponent string, zip *ZipArchive) error
 		htmlFiles := make([]string, len(htmls))
 		i := 0
 
-		relsPath := TEMPLATE_PATH + "/_rels/" + documentComponent + ".rels"
+		relsPath := partRelsPath(documentComponent)
 		rels, err := getRelsFromZip(zip, relsPath)
 		if err != nil {
 			return err
@@ -84,7 +90,7 @@ func ProcessHtmls(htmls Htmls, documentComponent string, zip *ZipArchive) error
 
 		for htmlId, htmlData := range htmls {
 			// Replace all period characters in the filename to play nice with more picky parsers (like Docx4j)
-			htmlName := fmt.Sprintf("template_%s_%s.html", strings.ReplaceAll(documentComponent, ".", "_"), htmlId)
+			htmlName := fmt.Sprintf("template_%s_%s.html", strings.NewReplacer(".", "_", "/", "_").Replace(documentComponent), htmlId)
 			slog.Debug(fmt.Sprintf("Writing html %s (%s)...\n", htmlId, htmlName))
 			htmlPath := fmt.Sprintf("%s/%s", TEMPLATE_PATH, htmlName)
 			htmlFiles[i] = "/" + htmlPath
@@ -94,7 +100,7 @@ func ProcessHtmls(htmls Htmls, documentComponent string, zip *ZipArchive) error
 			AddChild(rels, NewNonTextNode("Relationship", map[string]string{
 				"Id":     htmlId,
 				"Type":   "http://schemas.openxmlformats.org/officeDocument/2006/relationships/aFChunk",
-				"Target": htmlName,
+				"Target": partTarget(documentComponent, htmlName),
 			}, nil))
 		}
 
@@ -120,8 +126,7 @@ func ProcessLinks(links Links, documentComponent string, zip *ZipArchive) error
 		return nil
 	}
 	slog.Debug("Completing document.xml.rels...")
-	//relsPath = `${TEMPLATE_PATH}/_rels/${documentComponent}.rels`;
-	relsPath := fmt.Sprintf("%s/_rels/%s.rels", TEMPLATE_PATH, documentComponent)
+	relsPath := partRelsPath(documentComponent)
 	rels, err := getRelsFromZip(zip, relsPath)
 	if err != nil {
 		return err
@@ -143,9 +148,23 @@ func ProcessLinks(links Links, documentComponent string, zip *ZipArchive) error
 	return nil
 }
 
+// partRelsPath returns the path of the relationships of a document part,
+// given relative to TEMPLATE_PATH (e.g. header1.xml)
+func partRelsPath(documentComponent string) string {
+	return path.Join(TEMPLATE_PATH, path.Dir(documentComponent), "_rels", path.Base(documentComponent)+".rels")
+}
+
+// partTarget returns the relationship target, from a document part, of a file of TEMPLATE_PATH
+func partTarget(documentComponent string, name string) string {
+	if path.Dir(documentComponent) == "." {
+		return name
+	}
+	return "/" + TEMPLATE_PATH + "/" + name
+}
+
 func getRelsFromZip(zip *ZipArchive, relsPath string) (Node, error) {
 	relsXmlBytes, err := zip.GetFile(relsPath)
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return nil, err
 	}
 
@@ -185,40 +204,168 @@ func ParseTemplate(zip *ZipArchive) (*ParseTemplateResult, error) {
 	}
 
 	extras := make(map[string]Node)
-	i := 1
-	for i < 1000 {
-		extraPath := fmt.Sprintf("%s/header%d.xml", TEMPLATE_PATH, i)
-		extra, err := zip.GetFile(extraPath)
-		if err != nil {
-			break
-		}
-		extras[extraPath], err = ParseXml(string(extra))
-		if err != nil {
-			return nil, fmt.Errorf("ParseXml failed for header%d.xml: %w", i, err)
-		}
-		i++
+	extraKinds, err := findParts(zip, contentTypes, mainDocument, EXTRA_PART_KINDS)
+	if err != nil {
+		return nil, err
 	}
-	i = 1
-	for i < 1000 {
-		extraPath := fmt.Sprintf("%s/footer%d.xml", TEMPLATE_PATH, i)
+	for extraPath := range extraKinds {
 		extra, err := zip.GetFile(extraPath)
 		if err != nil {
-			break
+			return nil, err
 		}
 		extras[extraPath], err = ParseXml(string(extra))
 		if err != nil {
-			return nil, fmt.Errorf("ParseXml failed for footer%d.xml: %w", i, err)
+			return nil, fmt.Errorf("ParseXml failed for %s: %w", extraPath, err)
 		}
-		i++
 	}
 
-	return &ParseTemplateResult{
+	result := &ParseTemplateResult{
 		Root:         root,
 		MainDocument: mainDocument,
 		Zip:          zip,
 		ContentTypes: contentTypes,
 		Extras:       extras,
-	}, nil
+		ExtraKinds:   extraKinds,
+		Styles:       Styles{},
+	}
+
+	otherParts, err := findParts(zip, contentTypes, mainDocument, []string{NUMBERING_KIND, STYLES_KIND})
+	if err != nil {
+		return nil, err
+	}
+	for partPath, kind := range otherParts {
+		part, err := parsePath(zip, partPath)
+		if err != nil {
+			return nil, fmt.Errorf("ParseXml failed for %s: %w", partPath, err)
+		}
+		switch kind {
+		case NUMBERING_KIND:
+			result.NumberingPath = partPath
+			result.Numbering = part
+		case STYLES_KIND:
+			result.Styles = readStyles(part)
+		}
+	}
+
+	return result, nil
+}
+
+// EXTRA_PART_KINDS are the kinds of the document parts, other than the main document,
+// that can contain template commands. A kind is both the last segment of the relationship
+// type and the name in the content type of the part.
+var EXTRA_PART_KINDS = []string{"header", "footer", "footnotes", "endnotes", "comments"}
+
+// Kinds of the template parts used when rendering, but not containing template commands
+const (
+	NUMBERING_KIND = "numbering"
+	STYLES_KIND    = "styles"
+)
+
+// findParts finds the parts of the given kinds, e.g. EXTRA_PART_KINDS, by path,
+// in the relationships of the main document and in the content types
+func findParts(zip *ZipArchive, contentTypes *NonTextNode, mainDocument string, kinds []string) (map[string]string, error) {
+	found := map[string]string{}
+	addIfExists := func(partPath string, kind string) {
+		partPath = strings.TrimPrefix(path.Clean(partPath), "/")
+		if found[partPath] != "" || partPath == path.Join(TEMPLATE_PATH, mainDocument) {
+			return
+		}
+		if _, err := zip.GetFile(partPath); err == nil {
+			found[partPath] = kind
+		}
+	}
+
+	rels, err := getRelsFromZip(zip, partRelsPath(mainDocument))
+	if err != nil {
+		return nil, err
+	}
+	for _, rel := range rels.Children() {
+		relNode, ok := rel.(*NonTextNode)
+		if !ok || relNode.Attrs["TargetMode"] == "External" {
+			continue
+		}
+		relType := relNode.Attrs["Type"]
+		kind := relType[strings.LastIndex(relType, "/")+1:]
+		if !slices.Contains(kinds, kind) {
+			continue
+		}
+		target := relNode.Attrs["Target"]
+		if strings.HasPrefix(target, "/") {
+			addIfExists(target, kind)
+		} else {
+			addIfExists(path.Join(TEMPLATE_PATH, path.Dir(mainDocument), target), kind)
+		}
+	}
+
+	for _, override := range contentTypes.Children() {
+		overrideNode, ok := override.(*NonTextNode)
+		if !ok || overrideNode.Tag != "Override" {
+			continue
+		}
+		for _, kind := range kinds {
+			if overrideNode.Attrs["ContentType"] == PartContentType(kind) {
+				addIfExists(overrideNode.Attrs["PartName"], kind)
+			}
+		}
+	}
+
+	return found, nil
+}
+
+// PartContentType returns the content type of a WordprocessingML part of the given kind
+func PartContentType(kind string) string {
+	return "application/vnd.openxmlformats-officedocument.wordprocessingml." + kind + "+xml"
+}
+
+var emptyParts = map[string]string{
+	FOOTNOTES_KIND: `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:footnotes xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<w:footnote w:type="separator" w:id="-1"><w:p><w:pPr><w:spacing w:after="0" w:line="240" w:lineRule="auto"/></w:pPr><w:r><w:separator/></w:r></w:p></w:footnote>
+<w:footnote w:type="continuationSeparator" w:id="0"><w:p><w:pPr><w:spacing w:after="0" w:line="240" w:lineRule="auto"/></w:pPr><w:r><w:continuationSeparator/></w:r></w:p></w:footnote>
+</w:footnotes>`,
+	COMMENTS_KIND: `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:comments xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+</w:comments>`,
+	NUMBERING_KIND: `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+</w:numbering>`,
+}
+
+// NewPart returns the path and the empty content of a new part of the given kind,
+// next to the main document
+func NewPart(kind string, mainDocument string) (string, Node, error) {
+	root, err := ParseXml(emptyParts[kind])
+	if err != nil {
+		return "", nil, err
+	}
+	return path.Join(TEMPLATE_PATH, path.Dir(mainDocument), kind+".xml"), root, nil
+}
+
+// AddPartRelationship adds the relationship of a new part of the given kind to the main document rels
+func AddPartRelationship(kind string, partPath string, mainDocument string, zip *ZipArchive) error {
+	slog.Debug("Adding " + kind + " part to " + mainDocument + "...")
+	relsPath := partRelsPath(mainDocument)
+	rels, err := getRelsFromZip(zip, relsPath)
+	if err != nil {
+		return err
+	}
+	relId := kind
+	for i := 1; slices.ContainsFunc(rels.Children(), func(rel Node) bool {
+		relNode, ok := rel.(*NonTextNode)
+		return ok && relNode.Attrs["Id"] == relId
+	}); i++ {
+		relId = fmt.Sprintf("%s%d", kind, i)
+	}
+	AddChild(rels, NewNonTextNode("Relationship", map[string]string{
+		"Id":     relId,
+		"Type":   "http://schemas.openxmlformats.org/officeDocument/2006/relationships/" + kind,
+		"Target": path.Base(partPath),
+	}, nil))
+	finalRelsXml := BuildXml(rels, XmlOptions{
+		LiteralXmlDelimiter: DEFAULT_LITERAL_XML_DELIMITER,
+	}, "")
+	zip.SetFile(relsPath, finalRelsXml)
+	return nil
 }
 
 func parsePath(zip *ZipArchive, xmlPath string) (*NonTextNode, error) {
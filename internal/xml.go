@@ -127,7 +127,11 @@ func parseAttributes(attrs []xml.Attr) map[string]string {
 
 func sanitizeText(str string, options XmlOptions) string {
 	var out strings.Builder
-	segments := strings.Split(str, options.LiteralXmlDelimiter)
+	// without a delimiter, the whole text is escaped
+	segments := []string{str}
+	if options.LiteralXmlDelimiter != "" {
+		segments = strings.Split(str, options.LiteralXmlDelimiter)
+	}
 	fLiteral := false
 
 	for _, segment := range segments {
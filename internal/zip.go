@@ -55,13 +55,29 @@ func ZipSet(z *zip.Writer, filename string, data []byte) error {
 }
 
 type ZipArchive struct {
-	reader *zip.ReadCloser
+	reader *zip.Reader
+	closer io.Closer // optional, closes the underlying template file
 	writer *zip.Writer
 	files  map[string][]byte
 }
 
 func NewZipArchive(name string, w io.Writer) (*ZipArchive, error) {
-	reader, err := zip.OpenReader(name)
+	readCloser, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	writer := zip.NewWriter(w)
+	return &ZipArchive{
+		reader: &readCloser.Reader,
+		closer: readCloser,
+		writer: writer,
+		files:  make(map[string][]byte),
+	}, nil
+}
+
+// NewZipArchiveFromReader opens a zip archive from an in-memory (or any random access) source
+func NewZipArchiveFromReader(r io.ReaderAt, size int64, w io.Writer) (*ZipArchive, error) {
+	reader, err := zip.NewReader(r, size)
 	if err != nil {
 		return nil, err
 	}
@@ -101,6 +117,21 @@ func (za *ZipArchive) GetFile(name string) ([]byte, error) {
 	return data, nil
 }
 
+// UncompressedSize returns the size of the archive content once assembled,
+// before compression.
+func (za *ZipArchive) UncompressedSize() int64 {
+	var size int64
+	for _, data := range za.files {
+		size += int64(len(data))
+	}
+	for _, file := range za.reader.File {
+		if _, ok := za.files[file.Name]; !ok {
+			size += int64(file.UncompressedSize64)
+		}
+	}
+	return size
+}
+
 func (za *ZipArchive) Assemble() error {
 
 	names := make([]string, len(za.files))
@@ -132,11 +163,21 @@ func (za *ZipArchive) Assemble() error {
 			return err
 		}
 	}
-	
+
+	return nil
+}
+
+// Discard releases the archive without finishing it, so that nothing more is written to its output
+func (za *ZipArchive) Discard() error {
+	if za.closer != nil {
+		return za.closer.Close()
+	}
 	return nil
 }
 
 func (za *ZipArchive) Close() error {
-	za.reader.Close()
+	if za.closer != nil {
+		za.closer.Close()
+	}
 	return za.writer.Close()
 }
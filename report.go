@@ -1,11 +1,9 @@
 package godocx
 
 import (
-	"bytes"
-	"errors"
-	"fmt"
-	"log/slog"
-	"slices"
+	"context"
+	"io"
+	"io/fs"
 
 	"github.com/ArFnds/godocx-template/internal"
 )
@@ -19,6 +17,9 @@ const (
 // It parses the template file, processes any commands within the template
 // using provided data, and outputs the final document as a byte slice.
 //
+// To render the same template many times, use ParseTemplate once and
+// call Template.Render instead.
+//
 // Parameters:
 //   - templatePath: The file path to the template document.
 //   - data: A pointer to ReportData containing data to be inserted into the template.
@@ -26,129 +27,61 @@ const (
 // Returns:
 //   - A byte slice representing the generated document.
 //   - An error if any occurs during template parsing, processing, or document generation.
-func CreateReport(templatePath string, data *ReportData, options CreateReportOptions) (outBytes []byte, err error) {
-	
-	outBuffer := bytes.NewBuffer(outBytes)
-	zip, err := internal.NewZipArchive(templatePath, outBuffer)
-	if err != nil {
-		return nil, err
-	}
-	doCleanupOnDefer := true
-	defer func() {
-		if doCleanupOnDefer { // only do cleanup on early returns
-			errOnClose := zip.Close()
-			err = errors.Join(err, errOnClose)
-		}
-	}()
+func CreateReport(templatePath string, data *ReportData, options CreateReportOptions) ([]byte, error) {
+	return CreateReportContext(context.Background(), templatePath, data, options)
+}
 
-	// xml parse the document
-	parseResult, err := internal.ParseTemplate(zip)
+// CreateReportContext generates a report document like CreateReport.
+// The render stops with the context error as soon as ctx is cancelled
+// or its deadline is exceeded.
+func CreateReportContext(ctx context.Context, templatePath string, data *ReportData, options CreateReportOptions) ([]byte, error) {
+	tpl, err := ParseTemplate(templatePath)
 	if err != nil {
-		return nil, fmt.Errorf("ParseTemplate failed: %w", err)
-	}
-
-	if options.CmdDelimiter == nil {
-		options.CmdDelimiter = &internal.Delimiters{
-			Open:  DEFAULT_CMD_DELIMITER,
-			Close: DEFAULT_CMD_DELIMITER,
-		}
-	}
-	if options.LiteralXmlDelimiter == "" {
-		options.LiteralXmlDelimiter = internal.DEFAULT_LITERAL_XML_DELIMITER
-	}
-
-	xmlOptions := internal.XmlOptions{
-		LiteralXmlDelimiter: options.LiteralXmlDelimiter,
+		return nil, err
 	}
+	return tpl.RenderContext(ctx, data, options)
+}
 
-	preppedTemplate, err := internal.PreprocessTemplate(parseResult.Root, *options.CmdDelimiter)
+// CreateReportT generates a report document like CreateReport, but the data can be
+// any Go value, e.g. a struct or a pointer to a struct, without converting it to ReportData.
+// Paths in the template resolve through map keys and exported struct fields (including
+// the ones of embedded structs), named by their `docx:"name"` tag or by their name.
+func CreateReportT[T any](templatePath string, data T, options CreateReportOptions) ([]byte, error) {
+	tpl, err := ParseTemplate(templatePath)
 	if err != nil {
-		return nil, fmt.Errorf("PreprocessTemplate failed: %w", err)
+		return nil, err
 	}
+	return RenderT(tpl, data, options)
+}
 
-	result, err := internal.ProduceReport(data, preppedTemplate, internal.NewContext(options, 73086257))
-	//TODO ^ max id
+// CreateReportFrom generates a report document like CreateReport, but reads
+// the template from r (of the given size) and writes the final document to w.
+func CreateReportFrom(r io.ReaderAt, size int64, w io.Writer, data *ReportData, options CreateReportOptions) error {
+	tpl, err := ParseTemplateFrom(r, size)
 	if err != nil {
-		return nil, fmt.Errorf("ProduceReport failed: %w", err)
+		return err
 	}
+	return tpl.RenderTo(w, data, options)
+}
 
-	newXml := internal.BuildXml(result.Report, xmlOptions, "")
-
-	slog.Debug("Writing report...")
-	zip.SetFile("word/document.xml", newXml)
-
-	numImages := len(result.Images)
-	numHtmls := len(result.Htmls)
-	err = internal.ProcessImages(result.Images, parseResult.MainDocument, parseResult.Zip)
+// CreateReportFS generates a report document like CreateReport, but reads
+// the template file name from fsys (e.g. an embed.FS) and writes the final document to w.
+func CreateReportFS(fsys fs.FS, name string, w io.Writer, data *ReportData, options CreateReportOptions) error {
+	tpl, err := ParseTemplateFS(fsys, name)
 	if err != nil {
-		return nil, fmt.Errorf("ProcessImages failed: %w", err)
+		return err
 	}
-	err = internal.ProcessHtmls(result.Htmls, parseResult.MainDocument, parseResult.Zip)
-	if err != nil {
-		return nil, fmt.Errorf("ProcessHtmls failed: %w", err)
-	}
-	err = internal.ProcessLinks(result.Links, parseResult.MainDocument, parseResult.Zip)
-	if err != nil {
-		return nil, fmt.Errorf("ProcessLinks failed: %w", err)
-	}
-
-	// Additionals headers and footers
-	for extraPath, extraNode := range parseResult.Extras {
-		prepped, err := internal.PreprocessTemplate(extraNode, *options.CmdDelimiter)
-		if err != nil {
-			return nil, fmt.Errorf("PreprocessTemplate failed: %w", err)
-		}
-		r, err := internal.ProduceReport(data, prepped, internal.NewContext(options, 73086257))
-		if err != nil {
-			return nil, fmt.Errorf("ProduceReport failed: %w", err)
-		}
-		extraXml := internal.BuildXml(r.Report, xmlOptions, "")
-		slog.Debug(fmt.Sprintf("Writing %s...", extraPath))
-		zip.SetFile(extraPath, extraXml)
-	}
-
-	if numHtmls > 0 || numImages > 0 {
-		slog.Debug("Completing [Content_Types].xml...")
+	return tpl.RenderTo(w, data, options)
+}
 
-		contentTypes := parseResult.ContentTypes
-		children := contentTypes.Children()
-		ensureContentType := func(extension string, contentType string) {
-			containsExtension := slices.ContainsFunc(children, func(n internal.Node) bool {
-				nonTextNode, isNonTextNode := n.(*internal.NonTextNode)
-				return isNonTextNode && nonTextNode.Attrs["Extension"] == extension
-			})
-			if containsExtension {
-				return
-			}
-			internal.AddChild(contentTypes, internal.NewNonTextNode("Default", map[string]string{"Extension": extension, "ContentType": contentType}, nil))
-		}
-		if numImages > 0 {
-			slog.Debug("Completing [Content_Types].xml for IMAGES...")
-			ensureContentType("png", "image/png")
-			ensureContentType("jpg", "image/jpeg")
-			ensureContentType("jpeg", "image/jpeg")
-			ensureContentType("gif", "image/gif")
-			ensureContentType("bmp", "image/bmp")
-			ensureContentType("svg", "image/svg+xml")
-		}
-		if numHtmls > 0 {
-			slog.Debug("Completing [Content_Types].xml for HTML...")
-			ensureContentType("html", "text/html")
+func setDefaultOptions(options *CreateReportOptions) {
+	if options.CmdDelimiter == nil {
+		options.CmdDelimiter = &internal.Delimiters{
+			Open:  DEFAULT_CMD_DELIMITER,
+			Close: DEFAULT_CMD_DELIMITER,
 		}
-		finalContentTypesXml := internal.BuildXml(parseResult.ContentTypes, xmlOptions, "")
-		zip.SetFile(CONTENT_TYPES_PATH, finalContentTypesXml)
-	}
-
-	err = zip.Assemble()
-	if err != nil {
-		return nil, fmt.Errorf("Error assembling zip: %w", err)
 	}
-
-	err = zip.Close()
-	doCleanupOnDefer = false // zip was closed here by code path, no special cleanup needed
-	if err != nil {
-		return nil, fmt.Errorf("Error closing zip: %w", err)
+	if options.LiteralXmlDelimiter == "" {
+		options.LiteralXmlDelimiter = internal.DEFAULT_LITERAL_XML_DELIMITER
 	}
-
-	return outBuffer.Bytes(), nil
 }
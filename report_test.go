@@ -3,16 +3,44 @@ package godocx
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"iter"
+	"maps"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/ArFnds/godocx-template/internal"
 )
 
 func createTestDocx(content []byte, filename string) error {
+	docx, err := buildTestDocx(content)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, docx, 0644)
+}
+
+func buildTestDocx(content []byte) ([]byte, error) {
+	return buildTestDocxWithParts(content, nil)
+}
+
+// buildTestDocxWithParts builds a docx with the given main document,
+// plus the given parts, which can also replace the default ones
+func buildTestDocxWithParts(content []byte, parts map[string][]byte) ([]byte, error) {
 	// Create a buffer to write our archive to.
 	buf := new(bytes.Buffer)
 
@@ -36,25 +64,26 @@ func createTestDocx(content []byte, filename string) error {
 		<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
 		</Relationships>`),
 	}
+	maps.Copy(files, parts)
 
 	for name, content := range files {
 		f, err := w.Create(name)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		_, err = f.Write(content)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	// Make sure to check the error on Close.
 	err := w.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return os.WriteFile(filename, buf.Bytes(), 0644)
+	return buf.Bytes(), nil
 }
 func verifyDocxContent(t *testing.T, filename string, verifyFn func([]byte) error) {
 	// Open the docx file
@@ -172,9 +201,9 @@ func TestCreateReport(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Removing docx template file failed: %v", err)
 		}
-		
+
 	})
-	
+
 	// Test image processing
 	t.Run("image processing", func(t *testing.T) {
 		imageData := []byte{
@@ -860,3 +889,2289 @@ func TestCreateReport(t *testing.T) {
 	})
 
 }
+
+func TestTemplate(t *testing.T) {
+	t.Run("render a parsed template concurrently", func(t *testing.T) {
+		templateContent := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+			<w:body>
+				<w:p>
+					<w:r>
+						<w:t>+++IF show+++Hello +++name++++++END-IF+++</w:t>
+					</w:r>
+				</w:p>
+				<w:p>
+					<w:r>
+						<w:t>+++FOR item IN items+++</w:t>
+					</w:r>
+				</w:p>
+				<w:p>
+					<w:r>
+						<w:t>Item +++$item+++</w:t>
+					</w:r>
+				</w:p>
+				<w:p>
+					<w:r>
+						<w:t>+++END-FOR item+++</w:t>
+					</w:r>
+				</w:p>
+			</w:body>
+		</w:document>`)
+		err := createTestDocx(templateContent, "test_template_concurrent.docx")
+		if err != nil {
+			t.Fatalf("Failed to create test template: %v", err)
+		}
+		defer os.Remove("test_template_concurrent.docx")
+
+		tpl, err := ParseTemplate("test_template_concurrent.docx")
+		if err != nil {
+			t.Fatalf("ParseTemplate failed: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, 20)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				name := fmt.Sprintf("Name%d", i)
+				data := ReportData{
+					"show":  true,
+					"name":  name,
+					"items": []any{"A", "B"},
+				}
+				outBuf, err := tpl.Render(&data, CreateReportOptions{LiteralXmlDelimiter: "||"})
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				documentXml, err := readZipFile(outBuf, "word/document.xml")
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				for _, val := range []string{"Hello " + name, "Item A", "Item B"} {
+					if !bytes.Contains(documentXml, []byte(val)) {
+						errs[i] = fmt.Errorf("Generated document does not contain expected value: %s", val)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	})
+}
+
+func readZipFile(docx []byte, name string) ([]byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(docx), int64(len(docx)))
+	if err != nil {
+		return nil, err
+	}
+	rc, err := reader.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// readXmlZipFile reads a part of a docx, failing if it is not well-formed XML
+func readXmlZipFile(t *testing.T, docx []byte, name string) []byte {
+	t.Helper()
+	data, err := readZipFile(docx, name)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Expected well-formed XML in %s: %v\n%s", name, err, data)
+		}
+	}
+	return data
+}
+
+func TestCreateReportFromReader(t *testing.T) {
+	templateContent := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+			<w:body>
+				<w:p>
+					<w:r>
+						<w:t>+++name+++</w:t>
+					</w:r>
+				</w:p>
+			</w:body>
+		</w:document>`)
+	docx, err := buildTestDocx(templateContent)
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	data := ReportData{"name": "John"}
+	options := CreateReportOptions{LiteralXmlDelimiter: "||"}
+
+	t.Run("io.ReaderAt to io.Writer", func(t *testing.T) {
+		var out bytes.Buffer
+		err := CreateReportFrom(bytes.NewReader(docx), int64(len(docx)), &out, &data, options)
+		if err != nil {
+			t.Fatalf("CreateReportFrom failed: %v", err)
+		}
+		documentXml, err := readZipFile(out.Bytes(), "word/document.xml")
+		if err != nil {
+			t.Fatalf("Failed to read document.xml: %v", err)
+		}
+		if !bytes.Contains(documentXml, []byte("John")) {
+			t.Error("Generated document does not contain expected value: John")
+		}
+	})
+
+	t.Run("fs.FS to io.Writer", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"templates/template.docx": &fstest.MapFile{Data: docx},
+		}
+		var out bytes.Buffer
+		err := CreateReportFS(fsys, "templates/template.docx", &out, &data, options)
+		if err != nil {
+			t.Fatalf("CreateReportFS failed: %v", err)
+		}
+		documentXml, err := readZipFile(out.Bytes(), "word/document.xml")
+		if err != nil {
+			t.Fatalf("Failed to read document.xml: %v", err)
+		}
+		if !bytes.Contains(documentXml, []byte("John")) {
+			t.Error("Generated document does not contain expected value: John")
+		}
+
+		err = CreateReportFS(fsys, "templates/missing.docx", &out, &data, options)
+		if err == nil {
+			t.Error("Expected error for missing template, but got none")
+		}
+	})
+
+	t.Run("nothing written on error", func(t *testing.T) {
+		tpl, err := ParseTemplateBytes(docx)
+		if err != nil {
+			t.Fatalf("ParseTemplateBytes failed: %v", err)
+		}
+		var out bytes.Buffer
+		err = tpl.RenderTo(&out, &ReportData{}, options)
+		var keyErr *internal.KeyNotFoundError
+		if !errors.As(err, &keyErr) {
+			t.Fatalf("Expected KeyNotFoundError but got %v", err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("Expected nothing written after a failed render, got %d bytes", out.Len())
+		}
+	})
+}
+
+// renderTestTemplate renders a docx built around the given document.xml content
+func renderTestTemplate(ctx context.Context, content []byte, data ReportData, options CreateReportOptions) ([]byte, error) {
+	docx, err := buildTestDocx(content)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := ParseTemplateBytes(docx)
+	if err != nil {
+		return nil, err
+	}
+	return tpl.RenderContext(ctx, &data, options)
+}
+
+func TestRenderLimits(t *testing.T) {
+	loopTemplate := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+			<w:body>
+				<w:p>
+					<w:r>
+						<w:t>+++FOR item IN items+++</w:t>
+					</w:r>
+				</w:p>
+				<w:p>
+					<w:r>
+						<w:t>Item +++$item+++</w:t>
+					</w:r>
+				</w:p>
+				<w:p>
+					<w:r>
+						<w:t>+++END-FOR item+++</w:t>
+					</w:r>
+				</w:p>
+			</w:body>
+		</w:document>`)
+	items := make([]any, 100)
+	for i := range items {
+		items[i] = i
+	}
+	data := ReportData{"items": items}
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := renderTestTemplate(ctx, loopTemplate, data, CreateReportOptions{})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled but got %v", err)
+		}
+	})
+
+	t.Run("cancelled from a function", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		calls := 0
+		options := CreateReportOptions{
+			Functions: Functions{
+				"slow": func(args ...any) VarValue {
+					calls++
+					cancel()
+					return "x"
+				},
+			},
+		}
+		content := bytes.Replace(loopTemplate, []byte("+++$item+++"), []byte("+++slow($item)+++"), 1)
+		_, err := renderTestTemplate(ctx, content, data, options)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled but got %v", err)
+		}
+		if calls != 1 {
+			t.Errorf("Expected the render to stop after the first call, got %d calls", calls)
+		}
+	})
+
+	t.Run("maximum walking depth", func(t *testing.T) {
+		_, err := renderTestTemplate(context.Background(), loopTemplate, data, CreateReportOptions{MaximumWalkingDepth: 50})
+		var limitErr *WalkingDepthExceededError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("Expected WalkingDepthExceededError but got %v", err)
+		}
+
+		// the limit holds for sideways moves too
+		flat := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+			strings.Repeat("<w:p/>", 100) + `</w:body></w:document>`)
+		_, err = renderTestTemplate(context.Background(), flat, ReportData{}, CreateReportOptions{MaximumWalkingDepth: 50})
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("Expected WalkingDepthExceededError for sibling paragraphs but got %v", err)
+		}
+	})
+
+	t.Run("maximum loop iterations", func(t *testing.T) {
+		_, err := renderTestTemplate(context.Background(), loopTemplate, data, CreateReportOptions{MaximumLoopIterations: 10})
+		var limitErr *LoopIterationsExceededError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("Expected LoopIterationsExceededError but got %v", err)
+		}
+
+		_, err = renderTestTemplate(context.Background(), loopTemplate, data, CreateReportOptions{MaximumLoopIterations: 100})
+		if err != nil {
+			t.Fatalf("Expected no error with enough iterations but got %v", err)
+		}
+
+		// every item of every loop is counted, the first ones included
+		loops := []string{
+			"+++FOR a IN items+++", "+++$a+++", "+++END-FOR a+++",
+			"+++FOR b IN items++++++$b++++++END-FOR b+++",
+		}
+		sixItems := ReportData{"items": []any{1, 2, 3, 4, 5, 6}}
+		_, err = renderTestParagraphs(loops, sixItems, CreateReportOptions{MaximumLoopIterations: 11})
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("Expected LoopIterationsExceededError for 12 iterations but got %v", err)
+		}
+		_, err = renderTestParagraphs(loops, sixItems, CreateReportOptions{MaximumLoopIterations: 12})
+		if err != nil {
+			t.Fatalf("Expected no error for 12 iterations but got %v", err)
+		}
+	})
+
+	t.Run("maximum output size", func(t *testing.T) {
+		_, err := renderTestTemplate(context.Background(), loopTemplate, data, CreateReportOptions{MaximumOutputSize: 2000})
+		var limitErr *OutputSizeExceededError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("Expected OutputSizeExceededError but got %v", err)
+		}
+	})
+
+	t.Run("maximum image bytes", func(t *testing.T) {
+		content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+			<w:body>
+				<w:p>
+					<w:r>
+						<w:t>+++IMAGE img+++</w:t>
+					</w:r>
+				</w:p>
+			</w:body>
+		</w:document>`)
+		data := ReportData{
+			"img": &ImagePars{Width: 1, Height: 1, Data: make([]byte, 1024), Extension: ".png"},
+		}
+		_, err := renderTestTemplate(context.Background(), content, data, CreateReportOptions{MaximumImageBytes: 1000})
+		var limitErr *ImageBytesExceededError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("Expected ImageBytesExceededError but got %v", err)
+		}
+	})
+}
+
+// renderTestParagraphs renders one paragraph per text, and returns the generated document.xml
+func renderTestParagraphs(texts []string, data ReportData, options CreateReportOptions) (string, error) {
+	var body strings.Builder
+	for _, text := range texts {
+		body.WriteString("<w:p><w:r><w:t>")
+		body.WriteString(text)
+		body.WriteString("</w:t></w:r></w:p>")
+	}
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+		body.String() + `</w:body></w:document>`)
+	out, err := renderTestTemplate(context.Background(), content, data, options)
+	if err != nil {
+		return "", err
+	}
+	documentXml, err := readZipFile(out, "word/document.xml")
+	return string(documentXml), err
+}
+
+func TestExpressions(t *testing.T) {
+	data := ReportData{
+		"a":      "x==y",
+		"name":   "  john ",
+		"n":      7,
+		"price":  2.5,
+		"empty":  "",
+		"flag":   true,
+		"list":   []any{"a", "b"},
+		"person": map[string]any{"name": "Jane", "age": 30},
+		"code":   "Nan",
+		"none":   []any{},
+	}
+	options := CreateReportOptions{
+		Functions: Functions{
+			"upper": func(args ...any) VarValue { return strings.ToUpper(fmt.Sprint(args[0])) },
+			"trim":  func(args ...any) VarValue { return strings.TrimSpace(fmt.Sprint(args[0])) },
+			// Functions take precedence over the builtin functions
+			"join": func(args ...any) VarValue { return "custom join" },
+		},
+	}
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{`a == 'x==y' ? 'same' : 'different'`, "same"},
+		{`upper(trim(name))`, "JOHN"},
+		{`n + 3 * 2`, "13"},
+		{`(n + 3) * 2`, "20"},
+		{`n / 2`, "3.5"},
+		{`n % 4`, "3"},
+		{`price * 2`, "5"},
+		{`-n + 1`, "-6"},
+		{`'Hello, ' + person.name + '!'`, "Hello, Jane!"},
+		{`person.age >= 18 && flag ? 'adult' : 'minor'`, "adult"},
+		{`!flag || n > 5`, "true"},
+		{`!(n > 5)`, "false"},
+		{`person.nick? ?? 'none'`, "none"},
+		{`person.nick?`, ""},
+		{`person.nickname ?? 'none'`, "none"},
+		{`missing ?? person.name`, "Jane"},
+		{`empty || 'fallback'`, "fallback"},
+		{`len(list) == 2 && list`, "[a b]"},
+		{`"double \"quoted\""`, `double "quoted"`},
+		{`'a != b && c'`, "a != b &amp;&amp; c"},
+		{`n < 10 ? (n < 5 ? 'small' : 'medium') : 'large'`, "medium"},
+		{`len(list) + len(name)`, "9"},
+		{`join(list, '-')`, "custom join"},
+		{`(n - 7) && 'zero is truthy'`, "zero is truthy"},
+		{`none ? 'empty list is truthy' : 'falsy'`, "empty list is truthy"},
+		{`!empty && !null`, "true"},
+		{`code == 'Nan'`, "true"},
+		{`'Infinity' == 'inf'`, "false"},
+		{`'0x10' == '16'`, "false"},
+		{`'10' > '9' && '1e1' == '10.0'`, "true"},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			documentXml, err := renderTestParagraphs([]string{"[+++INS " + strings.ReplaceAll(strings.ReplaceAll(test.expression, "&", "&amp;"), "<", "&lt;") + "+++]"}, data, options)
+			if err != nil {
+				t.Fatalf("CreateReport failed: %v", err)
+			}
+			if !strings.Contains(documentXml, "["+test.expected+"]") {
+				t.Errorf("Expected [%s] in %s", test.expected, documentXml)
+			}
+		})
+	}
+
+	t.Run("syntax error", func(t *testing.T) {
+		_, err := renderTestParagraphs([]string{"+++INS (n + +++"}, data, options)
+		if err == nil {
+			t.Fatal("Expected a syntax error but got none")
+		}
+	})
+}
+
+type testAddress struct {
+	City string `docx:"city"`
+}
+
+type testEntity struct {
+	ID int
+}
+
+type testPerson struct {
+	testEntity
+	Name     string
+	Lastname string `docx:"last"`
+	Secret   string `docx:"-"`
+	Address  *testAddress
+	Scores   map[string]int
+}
+
+type testReport struct {
+	Title  string
+	People []testPerson
+	Owner  *testPerson
+}
+
+func TestCreateReportT(t *testing.T) {
+	templateContent := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+			<w:body>
+				<w:p><w:r><w:t>Title: +++Title+++ / +++title+++</w:t></w:r></w:p>
+				<w:p><w:r><w:t>+++FOR p IN People+++</w:t></w:r></w:p>
+				<w:p><w:r><w:t>[+++$p.ID+++ +++$p.name+++ +++$p.last+++ +++$p.Address?.city+++ +++$p.Scores.math+++]</w:t></w:r></w:p>
+				<w:p><w:r><w:t>+++END-FOR p+++</w:t></w:r></w:p>
+				<w:p><w:r><w:t>Owner: +++Owner.Name+++</w:t></w:r></w:p>
+			</w:body>
+		</w:document>`)
+	err := createTestDocx(templateContent, "test_template_struct.docx")
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	defer os.Remove("test_template_struct.docx")
+
+	report := &testReport{
+		Title: "Team",
+		People: []testPerson{
+			{testEntity: testEntity{ID: 1}, Name: "John", Lastname: "Doe", Address: &testAddress{City: "Paris"}, Scores: map[string]int{"math": 12}},
+			{testEntity: testEntity{ID: 2}, Name: "Jane", Lastname: "Roe", Scores: map[string]int{"math": 17}},
+		},
+	}
+	report.Owner = &report.People[1]
+
+	outBuf, err := CreateReportT("test_template_struct.docx", report, CreateReportOptions{})
+	if err != nil {
+		t.Fatalf("CreateReportT failed: %v", err)
+	}
+	documentXml, err := readZipFile(outBuf, "word/document.xml")
+	if err != nil {
+		t.Fatalf("Failed to read document.xml: %v", err)
+	}
+	for _, val := range []string{"Title: Team / Team", "[1 John Doe Paris 12]", "[2 Jane Roe  17]", "Owner: Jane"} {
+		if !bytes.Contains(documentXml, []byte(val)) {
+			t.Errorf("Generated document does not contain expected value: %s", val)
+		}
+	}
+
+	t.Run("ignored field", func(t *testing.T) {
+		content := bytes.Replace(templateContent, []byte("+++Owner.Name+++"), []byte("+++Owner.Secret+++"), 1)
+		docx, err := buildTestDocx(content)
+		if err != nil {
+			t.Fatalf("Failed to create test template: %v", err)
+		}
+		tpl, err := ParseTemplateBytes(docx)
+		if err != nil {
+			t.Fatalf("ParseTemplateBytes failed: %v", err)
+		}
+		_, err = RenderT(tpl, report, CreateReportOptions{})
+		var keyErr *internal.KeyNotFoundError
+		if !errors.As(err, &keyErr) {
+			t.Fatalf("Expected KeyNotFoundError but got %v", err)
+		}
+	})
+}
+
+func TestDataPaths(t *testing.T) {
+	data := ReportData{
+		"people": []any{
+			map[string]any{"name": "Alice"},
+			map[string]any{"name": "Bob"},
+			map[string]any{"name": "Charlie"},
+			map[string]any{"name": "Dave"},
+		},
+		"totals": map[string]any{"2024": 42, "net total": 40, "a.b": "dotted"},
+		"years":  map[int]string{2023: "last", 2024: "this"},
+		"matrix": [2][2]int{{1, 2}, {3, 4}},
+		"word":   "hello",
+		"accent": "Élodie",
+	}
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{`people[0].name`, "Alice"},
+		{`people[-1].name`, "Dave"},
+		{`people.1.name`, "Bob"},
+		{`people[ 2 ]['name']`, "Charlie"},
+		{`totals['2024']`, "42"},
+		{`totals["net total"]`, "40"},
+		{`totals['a.b']`, "dotted"},
+		{`years[2024]`, "this"},
+		{`matrix[1][0]`, "3"},
+		{`word[1:3]`, "el"},
+		{`word[-1]`, "o"},
+		{`accent[0]`, "É"},
+		{`accent[-1]`, "e"},
+		{`accent[0:2]`, "Él"},
+		{`accent[:-4]`, "Él"},
+		{`len(people[1:])`, "3"},
+		{`len(people[:-1])`, "3"},
+		{`len(people[10:])`, "0"},
+		{`people[10]?.name`, ""},
+		{`people[10]?.name ?? 'nobody'`, "nobody"},
+		{`people[10].name ?? 'nobody'`, "nobody"},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			documentXml, err := renderTestParagraphs([]string{"[+++INS " + test.expression + "+++]"}, data, CreateReportOptions{})
+			if err != nil {
+				t.Fatalf("CreateReport failed: %v", err)
+			}
+			if !strings.Contains(documentXml, "["+test.expected+"]") {
+				t.Errorf("Expected [%s] in %s", test.expected, documentXml)
+			}
+		})
+	}
+
+	t.Run("for loop over a slice", func(t *testing.T) {
+		documentXml, err := renderTestParagraphs([]string{
+			"+++FOR p IN people[1:3]+++",
+			"[+++$p.name+++]",
+			"+++END-FOR p+++",
+		}, data, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("CreateReport failed: %v", err)
+		}
+		for _, val := range []string{"[Bob]", "[Charlie]"} {
+			if !strings.Contains(documentXml, val) {
+				t.Errorf("Generated document does not contain expected value: %s", val)
+			}
+		}
+		for _, val := range []string{"[Alice]", "[Dave]"} {
+			if strings.Contains(documentXml, val) {
+				t.Errorf("Generated document contains unexpected value: %s", val)
+			}
+		}
+	})
+}
+
+func TestElse(t *testing.T) {
+	data := ReportData{
+		"grade": 15,
+		"items": []any{
+			map[string]any{"name": "apple", "stock": 0},
+			map[string]any{"name": "pear", "stock": 3},
+		},
+	}
+	chain := func(condition string) []string {
+		return []string{
+			"+++IF " + condition + "+++",
+			"[high]",
+			"+++ELSE-IF grade > 10+++",
+			"[medium]",
+			"+++ELSE-IF grade > 5+++",
+			"[low]",
+			"+++ELSE+++",
+			"[none]",
+			"+++END-IF+++",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		texts    []string
+		expected []string
+		missing  []string
+	}{
+		{"IF branch", chain("grade > 12"), []string{"[high]"}, []string{"[medium]", "[low]", "[none]"}},
+		{"first true ELSE-IF", chain("grade > 20"), []string{"[medium]"}, []string{"[high]", "[low]", "[none]"}},
+		{"ELSE branch", []string{"+++IF grade > 20+++", "[high]", "+++ELSE+++", "[none]", "+++END-IF+++"}, []string{"[none]"}, []string{"[high]"}},
+		{"ELSE in the IF paragraph", []string{"+++IF grade > 12+++[a]+++ELSE+++", "[b]", "+++END-IF+++"}, []string{"[a]"}, []string{"[b]"}},
+		{"ELSE in the IF paragraph, false IF", []string{"+++IF grade > 20+++[a]+++ELSE+++", "[b]", "+++END-IF+++"}, []string{"[b]"}, []string{"[a]"}},
+		{"same text node", []string{"[+++IF grade > 20+++high+++ELSE-IF grade > 10+++medium+++ELSE+++none+++END-IF+++]"}, []string{"[medium]"}, []string{"high", "none"}},
+		{"nested IF", []string{
+			"+++IF grade > 20+++",
+			"+++IF grade > 0+++",
+			"[nested]",
+			"+++ELSE+++",
+			"[nested else]",
+			"+++END-IF+++",
+			"+++ELSE+++",
+			"[outer else]",
+			"+++END-IF+++",
+		}, []string{"[outer else]"}, []string{"[nested]", "[nested else]"}},
+		{"inside FOR", []string{
+			"+++FOR item IN items+++",
+			"+++IF $item.stock > 0+++",
+			"[+++$item.name+++ in stock]",
+			"+++ELSE+++",
+			"[+++$item.name+++ sold out]",
+			"+++END-IF+++",
+			"+++END-FOR item+++",
+		}, []string{"[apple sold out]", "[pear in stock]"}, []string{"[apple in stock]", "[pear sold out]"}},
+		{"variable named like a command", []string{"[+++elsewhere+++]"}, []string{"[there]"}, nil},
+	}
+	data["elsewhere"] = "there"
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			documentXml, err := renderTestParagraphs(test.texts, data, CreateReportOptions{})
+			if err != nil {
+				t.Fatalf("CreateReport failed: %v", err)
+			}
+			for _, val := range test.expected {
+				if !strings.Contains(documentXml, val) {
+					t.Errorf("Generated document does not contain expected value: %s", val)
+				}
+			}
+			for _, val := range test.missing {
+				if strings.Contains(documentXml, val) {
+					t.Errorf("Generated document contains unexpected value: %s", val)
+				}
+			}
+			if strings.Contains(documentXml, "+++") {
+				t.Errorf("Generated document contains a command: %s", documentXml)
+			}
+		})
+	}
+
+	t.Run("table rows", func(t *testing.T) {
+		row := func(text string) string {
+			return "<w:tr><w:tc><w:p><w:r><w:t>" + text + "</w:t></w:r></w:p></w:tc></w:tr>"
+		}
+		content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body><w:tbl>` +
+			row("+++IF grade > 20+++") + row("[high]") + row("+++ELSE+++") + row("[other]") + row("+++END-IF+++") +
+			`</w:tbl></w:body></w:document>`)
+		out, err := renderTestTemplate(context.Background(), content, data, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("CreateReport failed: %v", err)
+		}
+		documentXml, err := readZipFile(out, "word/document.xml")
+		if err != nil {
+			t.Fatalf("Failed to read document.xml: %v", err)
+		}
+		if count := strings.Count(string(documentXml), "<w:tr>"); count != 1 {
+			t.Errorf("Expected 1 table row, got %d: %s", count, documentXml)
+		}
+		if !strings.Contains(string(documentXml), "[other]") || strings.Contains(string(documentXml), "[high]") {
+			t.Errorf("Unexpected table content: %s", documentXml)
+		}
+	})
+
+	t.Run("misplaced ELSE", func(t *testing.T) {
+		for _, texts := range [][]string{
+			{"+++ELSE+++"},
+			{"+++IF grade+++", "+++ELSE+++", "+++ELSE-IF grade+++", "+++END-IF+++"},
+			{"+++IF grade+++", "+++ELSE+++", "+++ELSE+++", "+++END-IF+++"},
+		} {
+			_, err := renderTestParagraphs(texts, data, CreateReportOptions{})
+			var cmdErr *internal.InvalidCommandError
+			if !errors.As(err, &cmdErr) {
+				t.Errorf("Expected InvalidCommandError for %v but got %v", texts, err)
+			}
+		}
+	})
+}
+
+func TestLoopVariables(t *testing.T) {
+	data := ReportData{
+		"people": []any{
+			map[string]any{"name": "Alice", "pets": []any{"cat", "dog"}},
+			map[string]any{"name": "Bob", "pets": []any{"fish"}},
+			map[string]any{"name": "Charlie", "pets": []any{}},
+		},
+	}
+
+	t.Run("separators", func(t *testing.T) {
+		documentXml, err := renderTestParagraphs([]string{
+			"[+++FOR p IN people++++++$p.name++++++IF $p_num == $p_count - 1+++ and +++ELSE-IF !$p_last+++, +++END-IF++++++END-FOR p+++]",
+		}, data, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("CreateReport failed: %v", err)
+		}
+		if !strings.Contains(documentXml, "[Alice, Bob and Charlie]") {
+			t.Errorf("Generated document does not contain the expected list: %s", documentXml)
+		}
+	})
+
+	t.Run("nested loops", func(t *testing.T) {
+		documentXml, err := renderTestParagraphs([]string{
+			"+++FOR p IN people+++",
+			"+++FOR pet IN $p.pets+++",
+			"[+++$p_num+++.+++$pet_num+++ +++$pet+++ first=+++$pet_first+++ last=+++$pet_last+++]",
+			"+++END-FOR pet+++",
+			"[+++$p.name+++ idx=+++$idx+++ first=+++$p_first+++ last=+++$p_last+++ count=+++$p_count+++]",
+			"+++END-FOR p+++",
+		}, data, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("CreateReport failed: %v", err)
+		}
+		for _, val := range []string{
+			"[1.1 cat first=true last=false]",
+			"[1.2 dog first=false last=true]",
+			"[2.1 fish first=true last=true]",
+			"[Alice idx=0 first=true last=false count=3]",
+			"[Bob idx=1 first=false last=false count=3]",
+			"[Charlie idx=2 first=false last=true count=3]",
+		} {
+			if !strings.Contains(documentXml, val) {
+				t.Errorf("Generated document does not contain expected value: %s", val)
+			}
+		}
+	})
+}
+
+func TestForSources(t *testing.T) {
+	newChannel := func() chan string {
+		channel := make(chan string, 3)
+		channel <- "a"
+		channel <- "b"
+		channel <- "c"
+		close(channel)
+		return channel
+	}
+	var names iter.Seq[string] = func(yield func(string) bool) {
+		for _, name := range []string{"x", "y", "z"} {
+			if !yield(name) {
+				return
+			}
+		}
+	}
+	var pairs iter.Seq2[string, int] = func(yield func(string, int) bool) {
+		_ = yield("one", 1) && yield("two", 2)
+	}
+
+	tests := []struct {
+		name     string
+		items    any
+		body     string
+		expected string
+	}{
+		{"map with string keys", map[string]int{"b": 2, "a": 1, "c": 3}, "+++$item.key+++=+++$item.value+++;", "[a=1;b=2;c=3;]"},
+		{"map with int keys", map[int]string{10: "ten", 9: "nine", 100: "hundred"}, "+++$item.value+++;", "[nine;ten;hundred;]"},
+		{"array", [3]int{4, 5, 6}, "+++$item+++;", "[4;5;6;]"},
+		{"slice with nil items", []any{"a", nil, "c"}, "+++$item_num+++;", "[1;2;3;]"},
+		{"channel", newChannel(), "+++$item+++;", "[a;b;c;]"},
+		{"iter.Seq", names, "+++$item+++;", "[x;y;z;]"},
+		{"iter.Seq2", pairs, "+++$item.key+++=+++$item.value+++;", "[one=1;two=2;]"},
+		{"nil channel", (chan string)(nil), "+++$item+++;", "[]"},
+		{"nil iter.Seq", iter.Seq[string](nil), "+++$item+++;", "[]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			documentXml, err := renderTestParagraphs([]string{
+				"[+++FOR item IN items+++" + test.body + "+++END-FOR item+++]",
+			}, ReportData{"items": test.items}, CreateReportOptions{})
+			if err != nil {
+				t.Fatalf("CreateReport failed: %v", err)
+			}
+			if !strings.Contains(documentXml, test.expected) {
+				t.Errorf("Expected %s in %s", test.expected, documentXml)
+			}
+		})
+	}
+
+	t.Run("range", func(t *testing.T) {
+		documentXml, err := renderTestParagraphs([]string{
+			"[+++FOR i IN range(1, n)++++++$i+++;+++END-FOR i+++]",
+			"(+++FOR i IN range(5, 1, -2)++++++$i+++;+++END-FOR i+++)",
+		}, ReportData{"n": 4}, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("CreateReport failed: %v", err)
+		}
+		for _, val := range []string{"[1;2;3;4;]", "(5;3;1;)"} {
+			if !strings.Contains(documentXml, val) {
+				t.Errorf("Generated document does not contain expected value: %s", val)
+			}
+		}
+	})
+
+	t.Run("endless iterator", func(t *testing.T) {
+		var endless iter.Seq[int] = func(yield func(int) bool) {
+			for i := 0; yield(i); i++ {
+			}
+		}
+		_, err := renderTestParagraphs([]string{
+			"+++FOR i IN items++++++$i++++++END-FOR i+++",
+		}, ReportData{"items": endless}, CreateReportOptions{MaximumLoopIterations: 100})
+		var loopErr *LoopIterationsExceededError
+		if !errors.As(err, &loopErr) {
+			t.Fatalf("Expected LoopIterationsExceededError but got %v", err)
+		}
+	})
+
+	t.Run("cancelled while waiting on a channel", func(t *testing.T) {
+		content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+			`<w:p><w:r><w:t>+++FOR i IN items++++++$i++++++END-FOR i+++</w:t></w:r></w:p></w:body></w:document>`)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := renderTestTemplate(ctx, content, ReportData{"items": make(chan int)}, CreateReportOptions{})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded but got %v", err)
+		}
+	})
+
+	t.Run("not iterable", func(t *testing.T) {
+		_, err := renderTestParagraphs([]string{
+			"+++FOR i IN items++++++$i++++++END-FOR i+++",
+		}, ReportData{"items": 12}, CreateReportOptions{})
+		if err == nil {
+			t.Fatal("Expected an error when looping over a number")
+		}
+	})
+}
+
+func TestForClauses(t *testing.T) {
+	data := ReportData{
+		"people": []any{
+			map[string]any{"first": "Ann", "last": "Smith", "age": 31, "active": true},
+			map[string]any{"first": "Bob", "last": "Jones", "age": 45, "active": false},
+			map[string]any{"first": "Cid", "last": "Brown", "age": 28, "active": true},
+			map[string]any{"first": "Dan", "last": "Smith", "age": 52, "active": true},
+		},
+		"limit": 2,
+	}
+
+	tests := []struct {
+		name     string
+		clauses  string
+		expected string
+	}{
+		{"where", "WHERE $p.active", "[Ann;Cid;Dan;]"},
+		{"order by", "ORDER BY $p.age", "[Cid;Ann;Bob;Dan;]"},
+		{"order by desc", "ORDER BY $p.age DESC", "[Dan;Bob;Ann;Cid;]"},
+		{"order by several keys", "ORDER BY $p.last, $p.first DESC", "[Cid;Bob;Dan;Ann;]"},
+		{"limit", "LIMIT 3", "[Ann;Bob;Cid;]"},
+		{"limit from data", "limit limit", "[Ann;Bob;]"},
+		{"all clauses", "WHERE $p.active &amp;&amp; $p.age > 30 ORDER BY $p.first DESC LIMIT 1", "[Dan;]"},
+		{"clauses in any order", "LIMIT 1 ORDER BY $p.first DESC WHERE $p.active", "[Dan;]"},
+		{"keywords in strings", "WHERE $p.last != 'ORDER BY' ORDER BY upper($p.first) desc", "[Dan;Cid;Bob;Ann;]"},
+	}
+	options := CreateReportOptions{
+		Functions: Functions{
+			"upper": func(args ...any) VarValue { return strings.ToUpper(fmt.Sprint(args[0])) },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			documentXml, err := renderTestParagraphs([]string{
+				"[+++FOR p IN people " + test.clauses + "++++++$p.first+++;+++END-FOR p+++]",
+			}, data, options)
+			if err != nil {
+				t.Fatalf("CreateReport failed: %v", err)
+			}
+			if !strings.Contains(documentXml, test.expected) {
+				t.Errorf("Expected %s in %s", test.expected, documentXml)
+			}
+		})
+	}
+
+	t.Run("over paragraphs", func(t *testing.T) {
+		documentXml, err := renderTestParagraphs([]string{
+			"+++FOR p IN people WHERE !$p.active+++",
+			"[+++$p.first+++ +++$p_num+++/+++$p_count+++]",
+			"+++END-FOR p+++",
+		}, data, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("CreateReport failed: %v", err)
+		}
+		if !strings.Contains(documentXml, "[Bob 1/1]") || strings.Contains(documentXml, "[Ann") {
+			t.Errorf("Unexpected loop content: %s", documentXml)
+		}
+	})
+
+	t.Run("invalid clauses", func(t *testing.T) {
+		for _, clauses := range []string{"WHERE", "LIMIT -1", "WHERE $p.active WHERE true"} {
+			_, err := renderTestParagraphs([]string{
+				"+++FOR p IN people " + clauses + "++++++$p.first++++++END-FOR p+++",
+			}, data, CreateReportOptions{FailFast: true})
+			if err == nil {
+				t.Errorf("Expected an error for %s", clauses)
+			} else if clauses == "LIMIT -1" && !strings.Contains(err.Error(), "LIMIT must be a non-negative integer") {
+				t.Errorf("Unexpected error for %s: %v", clauses, err)
+			}
+		}
+	})
+}
+
+func TestForGroupBy(t *testing.T) {
+	data := ReportData{
+		"orders": []any{
+			map[string]any{"id": 1, "customer": "Zoe", "total": 10},
+			map[string]any{"id": 2, "customer": "Adam", "total": 25},
+			map[string]any{"id": 3, "customer": "Zoe", "total": 5},
+			map[string]any{"id": 4, "customer": "Mia", "total": 0},
+			map[string]any{"id": 5, "customer": "Adam", "total": 7},
+		},
+		"lines": []any{
+			map[string]any{"id": 1, "qty": 1},
+			map[string]any{"id": 2, "qty": int64(1)},
+			map[string]any{"id": 3, "qty": 1.0},
+			map[string]any{"id": 4, "qty": 2},
+		},
+		"tagged": []any{
+			map[string]any{"id": 1, "tags": []string{"a"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		loop     string
+		expected string
+	}{
+		{"group by", "FOR g IN orders GROUP BY $g.customer", "[Zoe:1,3;Adam:2,5;Mia:4;]"},
+		{"where, group by and order by", "FOR g IN orders WHERE $g.total > 0 GROUP BY $g.customer ORDER BY $g.key", "[Adam:2,5;Zoe:1,3;]"},
+		{"order by count", "FOR g IN orders GROUP BY $g.customer ORDER BY $g.count DESC, $g.key LIMIT 2", "[Adam:2,5;Zoe:1,3;]"},
+		{"groupBy function", "FOR g IN groupBy(orders, 'customer')", "[Zoe:1,3;Adam:2,5;Mia:4;]"},
+		{"numbers of different types", "FOR g IN lines GROUP BY $g.qty", "[1:1,2,3;2:4;]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			documentXml, err := renderTestParagraphs([]string{
+				"[+++" + strings.ReplaceAll(test.loop, ">", "&gt;") + "++++++$g.key+++:" +
+					"+++FOR o IN $g.items++++++$o.id++++++IF !$o_last+++,+++END-IF++++++END-FOR o+++;" +
+					"+++END-FOR g+++]",
+			}, data, CreateReportOptions{})
+			if err != nil {
+				t.Fatalf("CreateReport failed: %v", err)
+			}
+			if !strings.Contains(documentXml, test.expected) {
+				t.Errorf("Expected %s in %s", test.expected, documentXml)
+			}
+		})
+	}
+
+	t.Run("keys which cannot be compared", func(t *testing.T) {
+		for _, loop := range []string{"FOR g IN tagged GROUP BY $g.tags", "FOR g IN groupBy(tagged, 'tags')"} {
+			_, err := renderTestParagraphs([]string{
+				"+++" + loop + "++++++$g.count++++++END-FOR g+++",
+			}, data, CreateReportOptions{FailFast: true})
+			if err == nil || !strings.Contains(err.Error(), "cannot group by a []string value") {
+				t.Errorf("Expected an error grouping by a slice for %s, got %v", loop, err)
+			}
+		}
+	})
+
+	t.Run("table rows", func(t *testing.T) {
+		row := func(text string) string {
+			return "<w:tr><w:tc><w:p><w:r><w:t>" + text + "</w:t></w:r></w:p></w:tc></w:tr>"
+		}
+		content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body><w:tbl>` +
+			row("+++FOR g IN orders GROUP BY $g.customer ORDER BY $g.key+++") +
+			row("[customer +++$g.key+++]") +
+			row("+++FOR o IN $g.items+++") +
+			row("[order +++$o.id+++]") +
+			row("+++END-FOR o+++") +
+			row("+++END-FOR g+++") +
+			`</w:tbl></w:body></w:document>`)
+		out, err := renderTestTemplate(context.Background(), content, data, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("CreateReport failed: %v", err)
+		}
+		documentXml, err := readZipFile(out, "word/document.xml")
+		if err != nil {
+			t.Fatalf("Failed to read document.xml: %v", err)
+		}
+		var rows []string
+		for _, part := range strings.Split(string(documentXml), "[")[1:] {
+			rows = append(rows, part[:strings.Index(part, "]")])
+		}
+		expected := []string{
+			"customer Adam", "order 2", "order 5",
+			"customer Mia", "order 4",
+			"customer Zoe", "order 1", "order 3",
+		}
+		if !slices.Equal(rows, expected) {
+			t.Errorf("Expected rows %v, got %v", expected, rows)
+		}
+		if count := strings.Count(string(documentXml), "<w:tr>"); count != len(expected) {
+			t.Errorf("Expected %d table rows, got %d", len(expected), count)
+		}
+	})
+}
+
+// testContentTypes returns a [Content_Types].xml with the main document and the given overrides
+func testContentTypes(overrides string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+			<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+			<Default Extension="xml" ContentType="application/xml"/>
+			<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>` +
+		overrides + `</Types>`)
+}
+
+// testDocumentRels returns a word/_rels/document.xml.rels with the given relationships
+func testDocumentRels(relationships string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		relationships + `</Relationships>`)
+}
+
+var testPngImage = []byte{
+	137, 80, 78, 71, 13, 10, 26, 10, 0, 0, 0, 13, 73, 72, 68, 82, 0, 0, 0, 50, 0, 0, 0, 50, 8, 2, 0, 0, 0, 145, 93, 31, 230, 0, 0, 0, 30, 73, 68, 65, 84, 120, 156, 237, 193, 49, 1, 0, 0, 0, 194, 160, 245, 79, 109, 8, 95, 160, 0, 0, 0, 0, 0, 0, 248, 13, 29, 126, 0, 1, 10, 82, 239, 54, 0, 0, 0, 0, 73, 69, 78, 68, 174, 66, 96, 130,
+}
+
+func TestHeaderFooterResources(t *testing.T) {
+	paragraph := func(text string) string {
+		return "<w:p><w:r><w:t>" + text + "</w:t></w:r></w:p>"
+	}
+	part := func(tag string, body string) []byte {
+		return []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:` + tag + ` xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` + body + `</w:` + tag + `>`)
+	}
+	content := part("document", "<w:body>"+paragraph("+++IMAGE img+++")+"</w:body>")
+	docx, err := buildTestDocxWithParts(content, map[string][]byte{
+		"[Content_Types].xml":          testContentTypes(`<Override PartName="/word/footer1.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.footer+xml"/>`),
+		"word/_rels/document.xml.rels": testDocumentRels(`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/header" Target="header1.xml"/>`),
+		"word/header1.xml":             part("hdr", paragraph("+++IMAGE img+++")+paragraph("+++LINK link+++")),
+		"word/footer1.xml":             part("ftr", paragraph("+++FOR i IN range(1, 2)+++")+paragraph("+++IMAGE img+++")+paragraph("+++END-FOR i+++")),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	tpl, err := ParseTemplateBytes(docx)
+	if err != nil {
+		t.Fatalf("ParseTemplateBytes failed: %v", err)
+	}
+	data := ReportData{
+		"img":  &ImagePars{Width: 2, Height: 2, Data: testPngImage, Extension: ".png"},
+		"link": &LinkPars{Url: "https://example.com", Label: "Example"},
+	}
+	out, err := tpl.Render(&data, CreateReportOptions{})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	docPrIds := map[string]string{}
+	docPrRegexp := regexp.MustCompile(`<wp:docPr [^>]*\bid="(\d+)"`)
+	relIdRegexp := regexp.MustCompile(`r:(?:embed|id)="([^"]+)"`)
+	for _, name := range []string{"document.xml", "header1.xml", "footer1.xml"} {
+		partXml, err := readZipFile(out, "word/"+name)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		rels, err := readZipFile(out, "word/_rels/"+name+".rels")
+		if err != nil {
+			t.Fatalf("Missing relationships of %s: %v", name, err)
+		}
+		for _, match := range relIdRegexp.FindAllStringSubmatch(string(partXml), -1) {
+			if !strings.Contains(string(rels), `Id="`+match[1]+`"`) {
+				t.Errorf("Relationship %s of %s not found in %s", match[1], name, rels)
+			}
+		}
+		for _, match := range docPrRegexp.FindAllStringSubmatch(string(partXml), -1) {
+			if other, ok := docPrIds[match[1]]; ok {
+				t.Errorf("docPr id %s used in both %s and %s", match[1], other, name)
+			}
+			docPrIds[match[1]] = name
+		}
+	}
+	if len(docPrIds) != 4 {
+		t.Errorf("Expected 4 images, got %v", docPrIds)
+	}
+	headerRels, _ := readZipFile(out, "word/_rels/header1.xml.rels")
+	if !strings.Contains(string(headerRels), `Target="https://example.com"`) {
+		t.Errorf("Hyperlink relationship not found in %s", headerRels)
+	}
+	contentTypes, _ := readZipFile(out, "[Content_Types].xml")
+	if !strings.Contains(string(contentTypes), `Extension="png"`) {
+		t.Errorf("png content type not found in %s", contentTypes)
+	}
+}
+
+func TestExtraPartsDiscovery(t *testing.T) {
+	part := func(tag string, text string) []byte {
+		return []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:` + tag + ` xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:p><w:r><w:t>` + text + `</w:t></w:r></w:p></w:` + tag + `>`)
+	}
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body><w:p><w:r><w:t>+++name+++</w:t></w:r></w:p></w:body></w:document>`)
+	relType := "http://schemas.openxmlformats.org/officeDocument/2006/relationships/"
+	docx, err := buildTestDocxWithParts(content, map[string][]byte{
+		"[Content_Types].xml": testContentTypes(
+			`<Override PartName="/word/footer3.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.footer+xml"/>`),
+		"word/_rels/document.xml.rels": testDocumentRels(
+			`<Relationship Id="rId1" Type="` + relType + `header" Target="header_first.xml"/>` +
+				`<Relationship Id="rId2" Type="` + relType + `header" Target="/word/header7.xml"/>` +
+				`<Relationship Id="rId3" Type="` + relType + `footer" Target="missing.xml"/>` +
+				`<Relationship Id="rId4" Type="` + relType + `styles" Target="styles.xml"/>`),
+		"word/header_first.xml": part("hdr", "first +++name+++"),
+		"word/header7.xml":      part("hdr", "seventh +++name+++"),
+		"word/footer3.xml":      part("ftr", "third +++name+++"),
+		"word/styles.xml":       part("styles", "+++name+++"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	tpl, err := ParseTemplateBytes(docx)
+	if err != nil {
+		t.Fatalf("ParseTemplateBytes failed: %v", err)
+	}
+	out, err := tpl.Render(&ReportData{"name": "John"}, CreateReportOptions{})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	for name, expected := range map[string]string{
+		"word/header_first.xml": "first John",
+		"word/header7.xml":      "seventh John",
+		"word/footer3.xml":      "third John",
+		"word/styles.xml":       "+++name+++",
+	} {
+		partXml, err := readZipFile(out, name)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if !strings.Contains(string(partXml), expected) {
+			t.Errorf("Expected %s in %s: %s", expected, name, partXml)
+		}
+	}
+}
+
+func TestNotesAndComments(t *testing.T) {
+	const ns = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+	paragraph := func(text string) string {
+		return "<w:p><w:r><w:t>" + text + "</w:t></w:r></w:p>"
+	}
+	relType := "http://schemas.openxmlformats.org/officeDocument/2006/relationships/"
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document ` + ns + `><w:body>` + paragraph("Body") + `</w:body></w:document>`)
+	docx, err := buildTestDocxWithParts(content, map[string][]byte{
+		"word/_rels/document.xml.rels": testDocumentRels(
+			`<Relationship Id="rId1" Type="` + relType + `footnotes" Target="footnotes.xml"/>` +
+				`<Relationship Id="rId2" Type="` + relType + `endnotes" Target="endnotes.xml"/>` +
+				`<Relationship Id="rId3" Type="` + relType + `comments" Target="comments.xml"/>`),
+		"word/footnotes.xml": []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><w:footnotes ` + ns + `>` +
+			`<w:footnote w:type="separator" w:id="-1"><w:p><w:r><w:separator/></w:r></w:p></w:footnote>` +
+			`<w:footnote w:type="continuationSeparator" w:id="0"><w:p><w:r><w:continuationSeparator/></w:r></w:p></w:footnote>` +
+			`<w:footnote w:id="1">` +
+			paragraph("+++FOR source IN sources+++") + paragraph("[See +++upper($source)+++]") + paragraph("+++END-FOR source+++") +
+			`</w:footnote>` +
+			`<w:footnote w:id="2">` + paragraph("+++IF draft+++") + paragraph("[Draft]") + paragraph("+++END-IF+++") + `</w:footnote>` +
+			`</w:footnotes>`),
+		"word/endnotes.xml": []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><w:endnotes ` + ns + `>` +
+			`<w:endnote w:id="1">` + paragraph("[+++IF draft+++draft+++ELSE+++final+++END-IF+++ version]") + `</w:endnote>` +
+			`</w:endnotes>`),
+		"word/comments.xml": []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><w:comments ` + ns + `>` +
+			`<w:comment w:id="0" w:author="Reviewer">` + paragraph("[Checked by +++reviewer+++]") + `</w:comment>` +
+			`</w:comments>`),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	tpl, err := ParseTemplateBytes(docx)
+	if err != nil {
+		t.Fatalf("ParseTemplateBytes failed: %v", err)
+	}
+	out, err := tpl.Render(&ReportData{
+		"sources":  []any{"a", "b"},
+		"draft":    false,
+		"reviewer": "Ann",
+	}, CreateReportOptions{
+		Functions: Functions{
+			"upper": func(args ...any) VarValue { return strings.ToUpper(fmt.Sprint(args[0])) },
+		},
+	})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	footnotes, _ := readZipFile(out, "word/footnotes.xml")
+	for _, val := range []string{"[See A]", "[See B]", "<w:separator", "<w:continuationSeparator"} {
+		if !strings.Contains(string(footnotes), val) {
+			t.Errorf("Expected %s in footnotes.xml: %s", val, footnotes)
+		}
+	}
+	if strings.Contains(string(footnotes), "[Draft]") {
+		t.Errorf("Unexpected draft footnote content: %s", footnotes)
+	}
+	// a note emptied by an IF must keep a paragraph
+	if match := regexp.MustCompile(`<w:footnote [^>]*w:id="2"[^>]*>\s*<w:p`).FindString(string(footnotes)); match == "" {
+		t.Errorf("Expected an empty paragraph in footnote 2: %s", footnotes)
+	}
+	endnotes, _ := readZipFile(out, "word/endnotes.xml")
+	if !strings.Contains(string(endnotes), "[final version]") {
+		t.Errorf("Expected [final version] in endnotes.xml: %s", endnotes)
+	}
+	comments, _ := readZipFile(out, "word/comments.xml")
+	if !strings.Contains(string(comments), "[Checked by Ann]") {
+		t.Errorf("Expected [Checked by Ann] in comments.xml: %s", comments)
+	}
+}
+
+func TestFootnoteAndCommentCommands(t *testing.T) {
+	const ns = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document ` + ns + `><w:body>` +
+		`<w:p><w:pPr><w:jc w:val="center"/></w:pPr><w:r><w:rPr><w:b/></w:rPr><w:t>Revenue+++FOOTNOTE source+++ grew</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>+++FOR item IN items+++</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>+++$item.name++++++COMMENT $item.note+++</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>+++END-FOR item+++</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>+++IF false+++</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>Hidden+++FOOTNOTE 'hidden'++++++COMMENT 'hidden'+++</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>+++END-IF+++</w:t></w:r></w:p>` +
+		`</w:body></w:document>`)
+	data := &ReportData{
+		"source": "Annual report & accounts || <draft>",
+		"items": []any{
+			map[string]any{"name": "First", "note": "Check this || <b>"},
+			map[string]any{"name": "Second", "note": map[string]any{"text": "Line 1\nLine 2", "author": "Ann", "initials": "AB"}},
+		},
+	}
+
+	t.Run("new parts", func(t *testing.T) {
+		docx, err := buildTestDocx(content)
+		if err != nil {
+			t.Fatalf("Failed to create test template: %v", err)
+		}
+		tpl, err := ParseTemplateBytes(docx)
+		if err != nil {
+			t.Fatalf("ParseTemplateBytes failed: %v", err)
+		}
+		out, err := tpl.Render(data, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+
+		document, _ := readZipFile(out, "word/document.xml")
+		doc := string(document)
+		if !regexp.MustCompile(`Revenue</w:t></w:r><w:r><w:rPr><w:vertAlign w:val="superscript"/></w:rPr><w:footnoteReference w:id="1"/></w:r><w:r>\s*<w:rPr>\s*<w:b/>\s*</w:rPr><w:t xml:space="preserve"> grew`).MatchString(doc) {
+			t.Errorf("Expected a footnote reference with the run properties around it: %s", doc)
+		}
+		for _, id := range []string{"1", "2"} {
+			if !regexp.MustCompile(`<w:commentRangeStart w:id="` + id + `"/>[\s\S]*<w:commentRangeEnd w:id="` + id + `"/>\s*<w:r>\s*<w:commentReference w:id="` + id + `"/>`).MatchString(doc) {
+				t.Errorf("Expected the range and reference of comment %s: %s", id, doc)
+			}
+		}
+		if strings.Contains(doc, "Hidden") || strings.Contains(doc, `w:id="3"`) {
+			t.Errorf("Unexpected notes of a skipped IF: %s", doc)
+		}
+
+		footnotes := readXmlZipFile(t, out, "word/footnotes.xml")
+		for _, val := range []string{`<w:separator/>`, `<w:footnoteRef/>`, "Annual report &amp; accounts &#124;&#124; &lt;draft&gt;"} {
+			if !strings.Contains(string(footnotes), val) {
+				t.Errorf("Expected %s in footnotes.xml: %s", val, footnotes)
+			}
+		}
+		comments := readXmlZipFile(t, out, "word/comments.xml")
+		for _, val := range []string{"Check this &#124;&#124; &lt;b&gt;", "Line 1", "<w:br/>", "Line 2", `w:author="Ann"`, `w:initials="AB"`} {
+			if !strings.Contains(string(comments), val) {
+				t.Errorf("Expected %s in comments.xml: %s", val, comments)
+			}
+		}
+		if strings.Contains(string(comments), "hidden") {
+			t.Errorf("Unexpected comment of a skipped IF: %s", comments)
+		}
+
+		rels, _ := readZipFile(out, "word/_rels/document.xml.rels")
+		for _, kind := range []string{"footnotes", "comments"} {
+			if !regexp.MustCompile(`<Relationship [^>]*Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/` + kind + `"`).Match(rels) {
+				t.Errorf("Expected a %s relationship: %s", kind, rels)
+			}
+		}
+		contentTypes, _ := readZipFile(out, "[Content_Types].xml")
+		for _, kind := range []string{"footnotes", "comments"} {
+			if !strings.Contains(string(contentTypes), "application/vnd.openxmlformats-officedocument.wordprocessingml."+kind+"+xml") {
+				t.Errorf("Expected a %s content type: %s", kind, contentTypes)
+			}
+		}
+	})
+
+	t.Run("existing parts", func(t *testing.T) {
+		relType := "http://schemas.openxmlformats.org/officeDocument/2006/relationships/"
+		docx, err := buildTestDocxWithParts(content, map[string][]byte{
+			"word/_rels/document.xml.rels": testDocumentRels(
+				`<Relationship Id="rId1" Type="` + relType + `footnotes" Target="footnotes.xml"/>` +
+					`<Relationship Id="rId2" Type="` + relType + `comments" Target="comments.xml"/>`),
+			"word/footnotes.xml": []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><w:footnotes ` + ns + `>` +
+				`<w:footnote w:type="separator" w:id="-1"><w:p><w:r><w:separator/></w:r></w:p></w:footnote>` +
+				`<w:footnote w:id="4"><w:p><w:r><w:t>Existing footnote</w:t></w:r></w:p></w:footnote>` +
+				`</w:footnotes>`),
+			"word/comments.xml": []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><w:comments ` + ns + `>` +
+				`<w:comment w:id="7" w:author="Reviewer"><w:p><w:r><w:t>Existing comment</w:t></w:r></w:p></w:comment>` +
+				`</w:comments>`),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create test template: %v", err)
+		}
+		tpl, err := ParseTemplateBytes(docx)
+		if err != nil {
+			t.Fatalf("ParseTemplateBytes failed: %v", err)
+		}
+		out, err := tpl.Render(data, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+
+		document, _ := readZipFile(out, "word/document.xml")
+		for _, val := range []string{`<w:footnoteReference w:id="5"/>`, `<w:commentReference w:id="8"/>`, `<w:commentReference w:id="9"/>`} {
+			if !strings.Contains(string(document), val) {
+				t.Errorf("Expected %s in document.xml: %s", val, document)
+			}
+		}
+		footnotes, _ := readZipFile(out, "word/footnotes.xml")
+		if !strings.Contains(string(footnotes), "Existing footnote") || !regexp.MustCompile(`<w:footnote w:id="5">`).Match(footnotes) {
+			t.Errorf("Expected the new footnote after the existing ones: %s", footnotes)
+		}
+		comments, _ := readZipFile(out, "word/comments.xml")
+		if !strings.Contains(string(comments), "Existing comment") || !strings.Contains(string(comments), "Check this") {
+			t.Errorf("Expected the new comments after the existing ones: %s", comments)
+		}
+		rels, _ := readZipFile(out, "word/_rels/document.xml.rels")
+		if n := strings.Count(string(rels), "<Relationship "); n != 2 {
+			t.Errorf("Expected no new relationship, got %d: %s", n, rels)
+		}
+	})
+}
+
+func TestConvertHtml(t *testing.T) {
+	const ns = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+	relType := "http://schemas.openxmlformats.org/officeDocument/2006/relationships/"
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document ` + ns + `><w:body>` +
+		`<w:p><w:r><w:t>Before</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>+++HTML html+++</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>After</w:t></w:r></w:p>` +
+		`</w:body></w:document>`)
+	html := `<h1>Report &amp; summary</h1>
+		<p>Some <b>bold</b>, <i>italic</i> and <span style="color: #f00; text-decoration: underline">red</span>
+		text<br>on two lines, with a <a href="https://example.com/?a=1&amp;b=2">link</a>.
+		<ul><li>First<li>Second<ol start="3"><li>Nested</ol></ul>
+		<table><thead><tr><th>Name<th>Value</thead><tr><td>A<td>1<tr><td colspan="2">Total</table>
+		<img src="data:image/png;base64,` + base64.StdEncoding.EncodeToString(testPngImage) + `" alt="Square">
+		<img src="https://example.com/remote.png" alt="Remote image">`
+
+	t.Run("new numbering", func(t *testing.T) {
+		docx, err := buildTestDocxWithParts(content, map[string][]byte{
+			"word/_rels/document.xml.rels": testDocumentRels(`<Relationship Id="rId1" Type="` + relType + `styles" Target="styles.xml"/>`),
+			"word/styles.xml": []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><w:styles ` + ns + `>` +
+				`<w:style w:type="paragraph" w:styleId="Titre1"><w:name w:val="heading 1"/></w:style>` +
+				`</w:styles>`),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create test template: %v", err)
+		}
+		tpl, err := ParseTemplateBytes(docx)
+		if err != nil {
+			t.Fatalf("ParseTemplateBytes failed: %v", err)
+		}
+		out, err := tpl.Render(&ReportData{"html": html}, CreateReportOptions{ConvertHtml: true})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+
+		document, _ := readZipFile(out, "word/document.xml")
+		doc := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(document), "><")
+		if strings.Contains(doc, "altChunk") || strings.Contains(doc, "HTML") {
+			t.Errorf("Expected no altChunk: %s", doc)
+		}
+		expected := []string{
+			`<w:p><w:pPr><w:pStyle w:val="Titre1"/></w:pPr><w:r><w:t xml:space="preserve">Report &amp; summary</w:t></w:r></w:p>`,
+			`<w:rPr><w:b/></w:rPr><w:t xml:space="preserve">bold</w:t>`,
+			`<w:rPr><w:i/></w:rPr><w:t xml:space="preserve">italic</w:t>`,
+			`<w:rPr><w:color w:val="FF0000"/><w:u w:val="single"/></w:rPr><w:t xml:space="preserve">red</w:t>`,
+			`<w:t xml:space="preserve"> text</w:t></w:r><w:r><w:br/></w:r>`,
+			`<w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t xml:space="preserve">First</w:t>`,
+			`<w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t xml:space="preserve">Second</w:t>`,
+			`<w:numPr><w:ilvl w:val="1"/><w:numId w:val="2"/></w:numPr></w:pPr><w:r><w:t xml:space="preserve">Nested</w:t>`,
+			`<w:trPr><w:tblHeader/></w:trPr>`,
+			`<w:gridSpan w:val="2"/>`,
+			`<w:t xml:space="preserve">Remote image</w:t>`,
+		}
+		for _, val := range expected {
+			if !strings.Contains(doc, val) {
+				t.Errorf("Expected %s in document.xml: %s", val, doc)
+			}
+		}
+		if !regexp.MustCompile(`<w:hyperlink [^>]*r:id="(link1)"[^>]*>.*?link</w:t>`).MatchString(doc) {
+			t.Errorf("Expected a hyperlink: %s", doc)
+		}
+		// 50x50 pixels at 96 dpi
+		if !regexp.MustCompile(`<wp:extent [^>]*cx="476250"`).MatchString(doc) {
+			t.Errorf("Expected the intrinsic size of the image: %s", doc)
+		}
+		if !regexp.MustCompile(`(?s)Before.*Report &amp; summary.*Total.*After`).MatchString(doc) {
+			t.Errorf("Expected the converted HTML in place of the command: %s", doc)
+		}
+
+		numbering, err := readZipFile(out, "word/numbering.xml")
+		if err != nil {
+			t.Fatalf("Expected a numbering part: %v", err)
+		}
+		for _, val := range []string{`w:numId="1"`, `w:numId="2"`, `<w:numFmt w:val="bullet"/>`, `<w:numFmt w:val="decimal"/>`, `<w:startOverride w:val="3"/>`} {
+			if !strings.Contains(string(numbering), val) {
+				t.Errorf("Expected %s in numbering.xml: %s", val, numbering)
+			}
+		}
+		rels, _ := readZipFile(out, "word/_rels/document.xml.rels")
+		for _, val := range []string{relType + "numbering", relType + "hyperlink", relType + "image"} {
+			if !strings.Contains(string(rels), val) {
+				t.Errorf("Expected a %s relationship: %s", val, rels)
+			}
+		}
+		contentTypes, _ := readZipFile(out, "[Content_Types].xml")
+		for _, val := range []string{"wordprocessingml.numbering+xml", `Extension="png"`} {
+			if !strings.Contains(string(contentTypes), val) {
+				t.Errorf("Expected %s in content types: %s", val, contentTypes)
+			}
+		}
+	})
+
+	t.Run("existing numbering", func(t *testing.T) {
+		docx, err := buildTestDocxWithParts(content, map[string][]byte{
+			"word/_rels/document.xml.rels": testDocumentRels(`<Relationship Id="rId1" Type="` + relType + `numbering" Target="numbering.xml"/>`),
+			"word/numbering.xml": []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><w:numbering ` + ns + `>` +
+				`<w:abstractNum w:abstractNumId="0"><w:lvl w:ilvl="0"><w:numFmt w:val="upperRoman"/></w:lvl></w:abstractNum>` +
+				`<w:num w:numId="5"><w:abstractNumId w:val="0"/></w:num>` +
+				`</w:numbering>`),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create test template: %v", err)
+		}
+		tpl, err := ParseTemplateBytes(docx)
+		if err != nil {
+			t.Fatalf("ParseTemplateBytes failed: %v", err)
+		}
+		out, err := tpl.Render(&ReportData{"html": html}, CreateReportOptions{ConvertHtml: true})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+
+		document, _ := readZipFile(out, "word/document.xml")
+		if !strings.Contains(string(document), `<w:numId w:val="6"/>`) || !strings.Contains(string(document), `<w:numId w:val="7"/>`) {
+			t.Errorf("Expected numbering ids after the template ones: %s", document)
+		}
+		numbering, _ := readZipFile(out, "word/numbering.xml")
+		compact := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(numbering), "><")
+		if !regexp.MustCompile(`w:abstractNumId="0".*w:abstractNumId="1".*w:abstractNumId="2".*<w:num w:numId="5">.*w:numId="6".*w:numId="7"`).MatchString(compact) {
+			t.Errorf("Expected the new definitions after the existing ones, and before the numberings: %s", compact)
+		}
+		rels, _ := readZipFile(out, "word/_rels/document.xml.rels")
+		if n := strings.Count(string(rels), relType+"numbering"); n != 1 {
+			t.Errorf("Expected a single numbering relationship, got %d: %s", n, rels)
+		}
+	})
+
+	t.Run("literal xml delimiter in the text", func(t *testing.T) {
+		docx, err := buildTestDocx(content)
+		if err != nil {
+			t.Fatalf("Failed to create test template: %v", err)
+		}
+		tpl, err := ParseTemplateBytes(docx)
+		if err != nil {
+			t.Fatalf("ParseTemplateBytes failed: %v", err)
+		}
+		out, err := tpl.Render(&ReportData{"html": `<p>a || b &lt; c || d</p>`}, CreateReportOptions{ConvertHtml: true})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		document := readXmlZipFile(t, out, "word/document.xml")
+		if !strings.Contains(string(document), `<w:t xml:space="preserve">a &#124;&#124; b &lt; c &#124;&#124; d</w:t>`) {
+			t.Errorf("Expected the delimiter to be escaped: %s", document)
+		}
+	})
+}
+
+func TestMarkdown(t *testing.T) {
+	const ns = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+	relType := "http://schemas.openxmlformats.org/officeDocument/2006/relationships/"
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document ` + ns + `><w:body>` +
+		`<w:p><w:r><w:t>Before</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>+++MARKDOWN md+++</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>After</w:t></w:r></w:p>` +
+		`</w:body></w:document>`)
+	docx, err := buildTestDocxWithParts(content, map[string][]byte{
+		"word/_rels/document.xml.rels": testDocumentRels(`<Relationship Id="rId1" Type="` + relType + `styles" Target="styles.xml"/>`),
+		"word/styles.xml": []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><w:styles ` + ns + `>` +
+			`<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/></w:style>` +
+			`<w:style w:type="paragraph" w:styleId="ListParagraph"><w:name w:val="List Paragraph"/></w:style>` +
+			`</w:styles>`),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	tpl, err := ParseTemplateBytes(docx)
+	if err != nil {
+		t.Fatalf("ParseTemplateBytes failed: %v", err)
+	}
+	md := "## Films & series\n\n" +
+		"Some **bold**, *italic* and ~~struck~~ text, with a [link](https://example.com).\n\n" +
+		"- First\n- Second\n\n" +
+		"3. Third\n4. Fourth\n\n" +
+		"| Name | Year |\n|------|------|\n| Alien | 1979 |\n\n" +
+		"```\nfunc main() {\n}\n```\n"
+	out, err := tpl.Render(&ReportData{"md": md}, CreateReportOptions{})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	document, _ := readZipFile(out, "word/document.xml")
+	doc := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(document), "><")
+	if strings.Contains(doc, "MARKDOWN") || strings.Contains(doc, "altChunk") {
+		t.Errorf("Expected the command to be replaced: %s", doc)
+	}
+	expected := []string{
+		`<w:p><w:pPr><w:pStyle w:val="Heading2"/></w:pPr><w:r><w:t xml:space="preserve">Films &amp; series</w:t></w:r></w:p>`,
+		`<w:rPr><w:b/></w:rPr><w:t xml:space="preserve">bold</w:t>`,
+		`<w:rPr><w:i/></w:rPr><w:t xml:space="preserve">italic</w:t>`,
+		`<w:rPr><w:strike/></w:rPr><w:t xml:space="preserve">struck</w:t>`,
+		`<w:pStyle w:val="ListParagraph"/><w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t xml:space="preserve">First</w:t>`,
+		`<w:numPr><w:ilvl w:val="0"/><w:numId w:val="2"/></w:numPr></w:pPr><w:r><w:t xml:space="preserve">Third</w:t>`,
+		`<w:trPr><w:tblHeader/></w:trPr>`,
+		`<w:t xml:space="preserve">Alien</w:t>`,
+	}
+	for _, val := range expected {
+		if !strings.Contains(doc, val) {
+			t.Errorf("Expected %s in document.xml: %s", val, doc)
+		}
+	}
+	if !regexp.MustCompile(`<w:hyperlink [^>]*r:id="link1"[^>]*>.*?link</w:t>`).MatchString(doc) {
+		t.Errorf("Expected a hyperlink: %s", doc)
+	}
+	if !regexp.MustCompile(`<w:t xml:space="preserve">func main\(\) \{</w:t></w:r><w:r><w:rPr><w:rFonts [^>]*/></w:rPr><w:br/></w:r>`).MatchString(doc) {
+		t.Errorf("Expected the lines of the code block: %s", doc)
+	}
+	if !regexp.MustCompile(`(?s)Before</w:t>.*Films &amp; series.*>}</w:t></w:r></w:p><w:p><w:r><w:t[^>]*>After`).MatchString(doc) {
+		t.Errorf("Expected the converted Markdown in place of the command, without a trailing line break: %s", doc)
+	}
+
+	numbering, err := readZipFile(out, "word/numbering.xml")
+	if err != nil {
+		t.Fatalf("Expected a numbering part: %v", err)
+	}
+	if !strings.Contains(string(numbering), `<w:startOverride w:val="3"/>`) {
+		t.Errorf("Expected the ordered list to start at 3: %s", numbering)
+	}
+
+	t.Run("literal xml delimiter in the text", func(t *testing.T) {
+		out, err := tpl.Render(&ReportData{"md": "a || b < c || d"}, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		document := readXmlZipFile(t, out, "word/document.xml")
+		if !strings.Contains(string(document), `<w:t xml:space="preserve">a &#124;&#124; b &lt; c &#124;&#124; d</w:t>`) {
+			t.Errorf("Expected the delimiter to be escaped: %s", document)
+		}
+	})
+}
+
+func TestRichText(t *testing.T) {
+	const ns = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document ` + ns + `><w:body><w:p><w:r>` +
+		`<w:rPr><w:rFonts w:ascii="Arial" w:hAnsi="Arial"/><w:i/><w:sz w:val="20"/></w:rPr>` +
+		`<w:t>Total: +++INS total+++ euros</w:t>` +
+		`</w:r></w:p></w:body></w:document>`)
+	docx, err := buildTestDocx(content)
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	tpl, err := ParseTemplateBytes(docx)
+	if err != nil {
+		t.Fatalf("ParseTemplateBytes failed: %v", err)
+	}
+	total := RichText{
+		{Text: "42", Bold: true, Color: "#FF0000"},
+		{Text: " <approx.> || ", Font: "Courier New", Size: 8},
+	}
+	out, err := tpl.Render(&ReportData{"total": total}, CreateReportOptions{LiteralXmlDelimiter: "||"})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	document, _ := readZipFile(out, "word/document.xml")
+	doc := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(document), "><")
+	// the properties of the command run are kept, in the order of the schema
+	patterns := []string{
+		`<w:rPr><w:rFonts [^>]*/><w:i/><w:sz w:val="20"/></w:rPr><w:t xml:space="preserve">Total: </w:t></w:r>`,
+		`<w:r><w:rPr><w:rFonts [^>]*w:ascii="Arial"[^>]*/><w:b/><w:i/><w:color w:val="FF0000"/><w:sz w:val="20"/></w:rPr><w:t xml:space="preserve">42</w:t></w:r>`,
+		`<w:r><w:rPr><w:rFonts [^>]*w:ascii="Courier New"[^>]*/><w:i/><w:sz w:val="16"/><w:szCs w:val="16"/></w:rPr><w:t xml:space="preserve"> &lt;approx.&gt; &#124;&#124; </w:t></w:r>`,
+		`<w:rPr><w:rFonts [^>]*/><w:i/><w:sz w:val="20"/></w:rPr><w:t xml:space="preserve"> euros</w:t>`,
+	}
+	for _, pattern := range patterns {
+		if !regexp.MustCompile(pattern).MatchString(doc) {
+			t.Errorf("Expected %s in document.xml: %s", pattern, doc)
+		}
+	}
+}
+
+func TestStyleCommands(t *testing.T) {
+	const ns = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+	relType := "http://schemas.openxmlformats.org/officeDocument/2006/relationships/"
+	buildTemplate := func(paragraph string) *Template {
+		t.Helper()
+		content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document ` + ns + `><w:body>` + paragraph + `</w:body></w:document>`)
+		docx, err := buildTestDocxWithParts(content, map[string][]byte{
+			"word/_rels/document.xml.rels": testDocumentRels(`<Relationship Id="rId1" Type="` + relType + `styles" Target="styles.xml"/>`),
+			"word/styles.xml": []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><w:styles ` + ns + `>` +
+				`<w:style w:type="paragraph" w:styleId="Alert"><w:name w:val="Alert"/></w:style>` +
+				`<w:style w:type="paragraph" w:styleId="Normal"><w:name w:val="Normal"/></w:style>` +
+				`<w:style w:type="character" w:styleId="lev"><w:name w:val="Strong"/></w:style>` +
+				`<w:style w:type="paragraph" w:styleId="QuoteCustom"><w:name w:val="quote"/></w:style>` +
+				`<w:style w:type="paragraph" w:styleId="Citation"><w:name w:val="Quote"/></w:style>` +
+				`</w:styles>`),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create test template: %v", err)
+		}
+		tpl, err := ParseTemplateBytes(docx)
+		if err != nil {
+			t.Fatalf("ParseTemplateBytes failed: %v", err)
+		}
+		return tpl
+	}
+
+	t.Run("paragraph and run styles", func(t *testing.T) {
+		tpl := buildTemplate(`<w:p><w:r><w:t>+++FOR row IN rows+++</w:t></w:r></w:p>` +
+			`<w:p><w:pPr><w:pStyle w:val="Normal"/><w:jc w:val="left"/></w:pPr>` +
+			`<w:r><w:t>+++STYLE $row.severity == 'high' ? 'Alert' : 'Normal'+++Issue: </w:t></w:r>` +
+			`<w:r><w:rPr><w:i/></w:rPr><w:t>+++CSTYLE 'Strong'++++++$row.name+++</w:t></w:r></w:p>` +
+			`<w:p><w:r><w:t>+++END-FOR row+++</w:t></w:r></w:p>`)
+		data := ReportData{"rows": []any{
+			map[string]any{"name": "Leak", "severity": "high"},
+			map[string]any{"name": "Typo", "severity": "low"},
+		}}
+		out, err := tpl.Render(&data, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		document, _ := readZipFile(out, "word/document.xml")
+		doc := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(document), "><")
+		doc = strings.ReplaceAll(doc, `<w:t xml:space="preserve"></w:t>`, "")
+		expected := []string{
+			`<w:p><w:pPr><w:pStyle w:val="Alert"/><w:jc w:val="left"/></w:pPr><w:r><w:t xml:space="preserve">Issue: </w:t></w:r>` +
+				`<w:r><w:rPr><w:rStyle w:val="lev"/><w:i/></w:rPr><w:t xml:space="preserve">Leak</w:t></w:r></w:p>`,
+			`<w:p><w:pPr><w:pStyle w:val="Normal"/><w:jc w:val="left"/></w:pPr><w:r><w:t xml:space="preserve">Issue: </w:t></w:r>` +
+				`<w:r><w:rPr><w:rStyle w:val="lev"/><w:i/></w:rPr><w:t xml:space="preserve">Typo</w:t></w:r></w:p>`,
+		}
+		for _, val := range expected {
+			if !strings.Contains(doc, val) {
+				t.Errorf("Expected %s in document.xml: %s", val, doc)
+			}
+		}
+	})
+
+	t.Run("several styles with the same name", func(t *testing.T) {
+		tpl := buildTemplate(`<w:p><w:r><w:t>+++STYLE 'Quote'+++Text</w:t></w:r></w:p>`)
+		for range 20 {
+			out, err := tpl.Render(&ReportData{}, CreateReportOptions{})
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			document, _ := readZipFile(out, "word/document.xml")
+			if !strings.Contains(string(document), `<w:pStyle w:val="Citation"/>`) {
+				t.Fatalf("Expected the style with the lowest id: %s", document)
+			}
+		}
+	})
+
+	t.Run("unknown style", func(t *testing.T) {
+		tpl := buildTemplate(`<w:p><w:r><w:t>+++STYLE 'Missing'+++Text</w:t></w:r></w:p>`)
+		_, err := tpl.Render(&ReportData{}, CreateReportOptions{})
+		var styleErr *StyleNotFoundError
+		if !errors.As(err, &styleErr) || styleErr.Style != "Missing" {
+			t.Errorf("Expected a StyleNotFoundError, got %v", err)
+		}
+	})
+}
+
+func TestTableCommand(t *testing.T) {
+	const ns = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+	relType := "http://schemas.openxmlformats.org/officeDocument/2006/relationships/"
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document ` + ns + `><w:body>` +
+		`<w:p><w:r><w:t>Before</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>+++TABLE grid+++</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>After</w:t></w:r></w:p>` +
+		`</w:body></w:document>`)
+	docx, err := buildTestDocxWithParts(content, map[string][]byte{
+		"word/_rels/document.xml.rels": testDocumentRels(`<Relationship Id="rId1" Type="` + relType + `styles" Target="styles.xml"/>`),
+		"word/styles.xml": []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><w:styles ` + ns + `>` +
+			`<w:style w:type="table" w:styleId="GridTable4"><w:name w:val="Grid Table 4"/></w:style>` +
+			`</w:styles>`),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	tpl, err := ParseTemplateBytes(docx)
+	if err != nil {
+		t.Fatalf("ParseTemplateBytes failed: %v", err)
+	}
+
+	t.Run("styled table", func(t *testing.T) {
+		grid := &TablePars{
+			Header: []any{"Film", "Year"},
+			Rows: [][]any{
+				{"Alien", 1979},
+				{TableCell{Value: "Total", Align: "center"}},
+			},
+			ColumnWidths: []float32{5, 2.54},
+			ColumnAligns: []string{"left", "right"},
+			Style:        "Grid Table 4",
+			RepeatHeader: true,
+		}
+		out, err := tpl.Render(&ReportData{"grid": grid}, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		document, _ := readZipFile(out, "word/document.xml")
+		doc := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(document), "><")
+		if strings.Contains(doc, "TABLE") || strings.Contains(doc, "tblBorders") {
+			t.Errorf("Expected the command to be replaced by a table without explicit borders: %s", doc)
+		}
+		patterns := []string{
+			`(?s)Before.*<w:tbl><w:tblPr><w:tblStyle w:val="GridTable4"/><w:tblW [^>]*w:w="4274"[^>]*/>.*</w:tbl><w:p><w:r><w:t[^>]*>After`,
+			`<w:tblLayout w:type="fixed"/>`,
+			`<w:tblGrid><w:gridCol w:w="2834"/><w:gridCol w:w="1440"/></w:tblGrid>`,
+			`<w:tr><w:trPr><w:tblHeader/></w:trPr><w:tc><w:tcPr><w:tcW [^>]*w:w="2834"[^>]*/></w:tcPr><w:p><w:pPr><w:jc w:val="left"/></w:pPr><w:r><w:t xml:space="preserve">Film</w:t>`,
+			`<w:pPr><w:jc w:val="right"/></w:pPr><w:r><w:t xml:space="preserve">1979</w:t>`,
+			`<w:pPr><w:jc w:val="center"/></w:pPr><w:r><w:t xml:space="preserve">Total</w:t></w:r></w:p></w:tc><w:tc><w:tcPr><w:tcW [^>]*/></w:tcPr><w:p><w:pPr><w:jc w:val="right"/></w:pPr><w:r><w:t xml:space="preserve"></w:t>`,
+		}
+		for _, pattern := range patterns {
+			if !regexp.MustCompile(pattern).MatchString(doc) {
+				t.Errorf("Expected %s in document.xml: %s", pattern, doc)
+			}
+		}
+	})
+
+	t.Run("default table", func(t *testing.T) {
+		grid := TablePars{Rows: [][]any{{"a\nb", RichText{{Text: "c", Italic: true}}}}}
+		out, err := tpl.Render(&ReportData{"grid": grid}, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		document, _ := readZipFile(out, "word/document.xml")
+		doc := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(document), "><")
+		patterns := []string{
+			`<w:tblW [^>]*w:type="pct"[^>]*/><w:tblBorders>`,
+			`<w:t xml:space="preserve">a</w:t><w:br/><w:t xml:space="preserve">b</w:t>`,
+			`<w:rPr><w:i/></w:rPr><w:t xml:space="preserve">c</w:t>`,
+		}
+		for _, pattern := range patterns {
+			if !regexp.MustCompile(pattern).MatchString(doc) {
+				t.Errorf("Expected %s in document.xml: %s", pattern, doc)
+			}
+		}
+	})
+
+	t.Run("missing column widths", func(t *testing.T) {
+		grid := TablePars{Rows: [][]any{{"a", "b", "c"}}, ColumnWidths: []float32{3}}
+		out, err := tpl.Render(&ReportData{"grid": grid}, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		document, _ := readZipFile(out, "word/document.xml")
+		doc := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(document), "><")
+		// the columns without a width share the rest of the page width
+		patterns := []string{
+			`<w:tblGrid><w:gridCol w:w="1700"/><w:gridCol w:w="3969"/><w:gridCol w:w="3969"/></w:tblGrid>`,
+			`<w:tcW [^>]*w:w="3969"[^>]*/></w:tcPr><w:p><w:r><w:t xml:space="preserve">c</w:t>`,
+		}
+		for _, pattern := range patterns {
+			if !regexp.MustCompile(pattern).MatchString(doc) {
+				t.Errorf("Expected %s in document.xml: %s", pattern, doc)
+			}
+		}
+	})
+
+	t.Run("page width of the section", func(t *testing.T) {
+		// landscape Letter page with 1 inch margins
+		content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+			`<w:p><w:r><w:t>+++TABLE grid+++</w:t></w:r></w:p>` +
+			`<w:p><w:r><w:t>+++HTML html+++</w:t></w:r></w:p>` +
+			`<w:sectPr><w:pgSz w:w="15840" w:h="12240" w:orient="landscape"/><w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440"/></w:sectPr>` +
+			`</w:body></w:document>`)
+		docx, err := buildTestDocx(content)
+		if err != nil {
+			t.Fatalf("Failed to create test template: %v", err)
+		}
+		tpl, err := ParseTemplateBytes(docx)
+		if err != nil {
+			t.Fatalf("ParseTemplateBytes failed: %v", err)
+		}
+		data := ReportData{
+			"grid": TablePars{Rows: [][]any{{"a", "b", "c"}}, ColumnWidths: []float32{2.54}},
+			"html": "<table><tr><td>x<td>y</table>",
+		}
+		out, err := tpl.Render(&data, CreateReportOptions{ConvertHtml: true})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		document, _ := readZipFile(out, "word/document.xml")
+		doc := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(document), "><")
+		for _, val := range []string{
+			`<w:tblGrid><w:gridCol w:w="1440"/><w:gridCol w:w="5760"/><w:gridCol w:w="5760"/></w:tblGrid>`,
+			`<w:tblGrid><w:gridCol w:w="6480"/><w:gridCol w:w="6480"/></w:tblGrid>`,
+		} {
+			if !strings.Contains(doc, val) {
+				t.Errorf("Expected %s in document.xml: %s", val, doc)
+			}
+		}
+	})
+
+	t.Run("literal xml delimiter in the cells", func(t *testing.T) {
+		grid := TablePars{Header: []any{"x || <y>"}, Rows: [][]any{{RichText{{Text: "a || b", Bold: true}}}}}
+		out, err := tpl.Render(&ReportData{"grid": grid}, CreateReportOptions{})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		document := readXmlZipFile(t, out, "word/document.xml")
+		for _, val := range []string{`x &#124;&#124; &lt;y&gt;`, `a &#124;&#124; b`} {
+			if !strings.Contains(string(document), val) {
+				t.Errorf("Expected %s in document.xml: %s", val, document)
+			}
+		}
+	})
+
+	t.Run("unknown style", func(t *testing.T) {
+		_, err := tpl.Render(&ReportData{"grid": &TablePars{Rows: [][]any{{1}}, Style: "Missing"}}, CreateReportOptions{})
+		var styleErr *StyleNotFoundError
+		if !errors.As(err, &styleErr) {
+			t.Errorf("Expected a StyleNotFoundError, got %v", err)
+		}
+	})
+}
+
+func TestChartCommand(t *testing.T) {
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+		`<w:p><w:r><w:t>+++CHART sales+++</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>+++CHART points+++</w:t></w:r></w:p>` +
+		`</w:body></w:document>`)
+	docx, err := buildTestDocx(content)
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	tpl, err := ParseTemplateBytes(docx)
+	if err != nil {
+		t.Fatalf("ParseTemplateBytes failed: %v", err)
+	}
+	data := ReportData{
+		"sales": &ChartPars{
+			Type:       "bar",
+			Title:      "Sales & costs || <net>",
+			Categories: []string{"Q1", "Q2"},
+			Series:     []ChartSeries{{Name: "Sales", Values: []float64{10, 12.5}}, {Name: "Costs || <fixed>", Values: []float64{8, 9}}},
+			Width:      10,
+			Height:     5,
+		},
+		"points": ChartPars{
+			Type:       "scatter",
+			Categories: []string{"1", "2.5"},
+			Series:     []ChartSeries{{Name: "Measures", Values: []float64{3, 4}}},
+		},
+	}
+	out, err := tpl.Render(&data, CreateReportOptions{})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	document, _ := readZipFile(out, "word/document.xml")
+	doc := string(document)
+	charts := regexp.MustCompile(`<c:chart [^>]*r:id="(chart\d+)"`).FindAllStringSubmatch(doc, -1)
+	if len(charts) != 2 {
+		t.Fatalf("Expected 2 inline charts: %s", doc)
+	}
+	if !regexp.MustCompile(`<wp:extent [^>]*cx="3600000"`).MatchString(doc) {
+		t.Errorf("Expected the size of the chart: %s", doc)
+	}
+
+	rels, _ := readZipFile(out, "word/_rels/document.xml.rels")
+	contentTypes, _ := readZipFile(out, "[Content_Types].xml")
+	for i, chart := range charts {
+		name := "template_document.xml_" + chart[1]
+		if !regexp.MustCompile(`<Relationship [^>]*Id="`+chart[1]+`"[^>]*/>`).Match(rels) || !strings.Contains(string(rels), `Target="charts/`+name+`.xml"`) {
+			t.Errorf("Expected a relationship to the chart part: %s", rels)
+		}
+		if !strings.Contains(string(contentTypes), `/word/charts/`+name+`.xml`) {
+			t.Errorf("Expected a content type for the chart part: %s", contentTypes)
+		}
+		chartXml := readXmlZipFile(t, out, "word/charts/"+name+".xml")
+		chartRels, _ := readZipFile(out, "word/charts/_rels/"+name+".xml.rels")
+		if !strings.Contains(string(chartRels), `Target="../embeddings/`+name+`.xlsx"`) {
+			t.Errorf("Expected a relationship to the workbook: %s", chartRels)
+		}
+		workbook, err := readZipFile(out, "word/embeddings/"+name+".xlsx")
+		if err != nil {
+			t.Fatalf("Expected an embedded workbook: %v", err)
+		}
+		sheet := readXmlZipFile(t, workbook, "xl/worksheets/sheet1.xml")
+
+		compact := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(chartXml), "><")
+		var expected []string
+		if i == 0 {
+			expected = []string{
+				`<c:barDir val="col"/>`,
+				`<a:t>Sales &amp; costs || &lt;net&gt;</a:t>`,
+				`<c:f>Sheet1!$A$2:$A$3</c:f><c:strCache><c:ptCount val="2"/><c:pt idx="0"><c:v>Q1</c:v>`,
+				`<c:f>Sheet1!$C$1</c:f><c:strCache><c:ptCount val="1"/><c:pt idx="0"><c:v>Costs || &lt;fixed&gt;</c:v>`,
+				`<c:f>Sheet1!$B$2:$B$3</c:f><c:numCache><c:formatCode>General</c:formatCode><c:ptCount val="2"/><c:pt idx="0"><c:v>10</c:v></c:pt><c:pt idx="1"><c:v>12.5</c:v>`,
+				`<c:externalData r:id="rId1">`,
+			}
+			if !regexp.MustCompile(`<c [^>]*r="B3"[^>]*>\s*<v>12.5</v>`).Match(sheet) || !strings.Contains(string(sheet), `<t>Costs || &lt;fixed&gt;</t>`) {
+				t.Errorf("Expected the values in the workbook: %s", sheet)
+			}
+		} else {
+			expected = []string{`<c:scatterChart>`, `<c:xVal><c:numRef><c:f>Sheet1!$A$2:$A$3</c:f>`, `<c:autoTitleDeleted val="1"/>`}
+		}
+		for _, val := range expected {
+			if !strings.Contains(compact, val) {
+				t.Errorf("Expected %s in %s: %s", val, name, compact)
+			}
+		}
+	}
+	if !strings.Contains(string(contentTypes), `Extension="xlsx"`) {
+		t.Errorf("Expected a content type for the workbooks: %s", contentTypes)
+	}
+
+	_, err = tpl.Render(&ReportData{"sales": &ChartPars{Type: "radar"}, "points": nil}, CreateReportOptions{})
+	if err == nil || !strings.Contains(err.Error(), "ChartError") {
+		t.Errorf("Expected a ChartError for an unknown chart type, got %v", err)
+	}
+}
+
+func TestTemplateChartData(t *testing.T) {
+	relType := "http://schemas.openxmlformats.org/officeDocument/2006/relationships/"
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+			xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing"
+			xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main"
+			xmlns:c="http://schemas.openxmlformats.org/drawingml/2006/chart"
+			xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><w:body>` +
+		`<w:p><w:r><w:drawing><wp:inline><wp:extent cx="5486400" cy="3200400"/>` +
+		`<wp:docPr id="1" name="Chart 1" descr="chart:report.sales"/>` +
+		`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/chart"><c:chart r:id="rId5"/></a:graphicData></a:graphic>` +
+		`</wp:inline></w:drawing></w:r></w:p>` +
+		`</w:body></w:document>`)
+	chart := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<c:chartSpace xmlns:c="http://schemas.openxmlformats.org/drawingml/2006/chart" xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<c:chart><c:plotArea><c:barChart><c:barDir val="col"/><c:grouping val="clustered"/>` +
+		`<c:ser><c:idx val="0"/><c:order val="0"/>` +
+		`<c:tx><c:strRef><c:f>'Feuil 1'!$B$1</c:f><c:strCache><c:ptCount val="1"/><c:pt idx="0"><c:v>Old</c:v></c:pt></c:strCache></c:strRef></c:tx>` +
+		`<c:spPr><a:solidFill><a:srgbClr val="4472C4"/></a:solidFill></c:spPr>` +
+		`<c:cat><c:strRef><c:f>'Feuil 1'!$A$2:$A$2</c:f><c:strCache><c:ptCount val="1"/><c:pt idx="0"><c:v>X</c:v></c:pt></c:strCache></c:strRef></c:cat>` +
+		`<c:val><c:numRef><c:f>'Feuil 1'!$B$2:$B$2</c:f><c:numCache><c:formatCode>0.0</c:formatCode><c:ptCount val="1"/><c:pt idx="0"><c:v>1</c:v></c:pt></c:numCache></c:numRef></c:val>` +
+		`</c:ser><c:gapWidth val="219"/><c:axId val="1"/><c:axId val="2"/></c:barChart></c:plotArea></c:chart>` +
+		`<c:externalData r:id="rId1"><c:autoUpdate val="0"/></c:externalData></c:chartSpace>`
+	docx, err := buildTestDocxWithParts(content, map[string][]byte{
+		"word/_rels/document.xml.rels": testDocumentRels(`<Relationship Id="rId5" Type="` + relType + `chart" Target="charts/chart1.xml"/>`),
+		"word/charts/chart1.xml":       []byte(chart),
+		"word/charts/_rels/chart1.xml.rels": []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+			<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="` + relType + `package" Target="../embeddings/Microsoft_Excel_Worksheet.xlsx"/></Relationships>`),
+		"word/embeddings/Microsoft_Excel_Worksheet.xlsx": []byte("old workbook"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	tpl, err := ParseTemplateBytes(docx)
+	if err != nil {
+		t.Fatalf("ParseTemplateBytes failed: %v", err)
+	}
+	data := ReportData{"report": map[string]any{"sales": &ChartData{
+		Categories: []string{"Jan", "Feb || <b>", "Mar"},
+		Series: []ChartSeries{
+			{Name: "2023", Values: []float64{1, 2, 3}},
+			{Name: "2024 || <e>", Values: []float64{4, 5, 6.5}},
+		},
+	}}}
+	out, err := tpl.Render(&data, CreateReportOptions{})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	chartXml := readXmlZipFile(t, out, "word/charts/chart1.xml")
+	compact := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(chartXml), "><")
+	if n := strings.Count(compact, "<c:ser>"); n != 2 {
+		t.Errorf("Expected 2 series, got %d: %s", n, compact)
+	}
+	expected := []string{
+		`<c:gapWidth val="219"/>`,
+		`<c:idx val="0"/><c:order val="0"/><c:tx><c:strRef><c:f>'Feuil 1'!$B$1</c:f><c:strCache><c:ptCount val="1"/><c:pt idx="0"><c:v>2023</c:v></c:pt></c:strCache></c:strRef></c:tx><c:spPr><a:solidFill><a:srgbClr val="4472C4"/>`,
+		`<c:cat><c:strRef><c:f>'Feuil 1'!$A$2:$A$4</c:f><c:strCache><c:ptCount val="3"/><c:pt idx="0"><c:v>Jan</c:v></c:pt>`,
+		`<c:val><c:numRef><c:f>'Feuil 1'!$B$2:$B$4</c:f><c:numCache><c:formatCode>0.0</c:formatCode><c:ptCount val="3"/>`,
+		`<c:idx val="1"/><c:order val="1"/><c:tx><c:strRef><c:f>'Feuil 1'!$C$1</c:f><c:strCache><c:ptCount val="1"/><c:pt idx="0"><c:v>2024 || &lt;e&gt;</c:v></c:pt></c:strCache></c:strRef></c:tx><c:cat>`,
+		`<c:f>'Feuil 1'!$C$2:$C$4</c:f>`,
+		`<c:pt idx="1"><c:v>Feb || &lt;b&gt;</c:v></c:pt>`,
+		`<c:pt idx="2"><c:v>6.5</c:v></c:pt>`,
+	}
+	for _, val := range expected {
+		if !strings.Contains(compact, val) {
+			t.Errorf("Expected %s in chart1.xml: %s", val, compact)
+		}
+	}
+
+	workbook, _ := readZipFile(out, "word/embeddings/Microsoft_Excel_Worksheet.xlsx")
+	workbookXml, err := readZipFile(workbook, "xl/workbook.xml")
+	if err != nil {
+		t.Fatalf("Expected the workbook to be replaced: %v", err)
+	}
+	if !strings.Contains(string(workbookXml), `name="Feuil 1"`) {
+		t.Errorf("Expected the sheet name of the chart formulas: %s", workbookXml)
+	}
+	sheet := readXmlZipFile(t, workbook, "xl/worksheets/sheet1.xml")
+	if !regexp.MustCompile(`<c [^>]*r="C4"[^>]*>\s*<v>6.5</v>`).Match(sheet) || !strings.Contains(string(sheet), `<t>2024 || &lt;e&gt;</t>`) {
+		t.Errorf("Expected the values in the workbook: %s", sheet)
+	}
+
+	_, err = tpl.Render(&ReportData{"report": map[string]any{"sales": "not a chart"}}, CreateReportOptions{})
+	if err == nil {
+		t.Errorf("Expected an error for a chart bound to something else than chart data")
+	}
+}
+
+func TestPlaceholderImage(t *testing.T) {
+	placeholder := func(command string) string {
+		return `<w:p><w:r><w:drawing><wp:anchor behindDoc="1" distT="0" distB="0" distL="114300" distR="114300">` +
+			`<wp:positionH relativeFrom="page"><wp:posOffset>540000</wp:posOffset></wp:positionH>` +
+			`<wp:extent cx="2000000" cy="1000000"/><wp:wrapSquare wrapText="bothSides"/>` +
+			`<wp:docPr id="7" name="Picture 7" descr="` + command + `"/>` +
+			`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture"><pic:pic>` +
+			`<pic:nvPicPr><pic:cNvPr id="0" name="Picture 7" descr="` + command + `"/><pic:cNvPicPr/></pic:nvPicPr>` +
+			`<pic:blipFill><a:blip r:embed="rId9"><a:extLst><a:ext uri="{28A0092B-C50C-407E-A947-70E740481C1C}"><a14:useLocalDpi val="0"/></a:ext></a:extLst></a:blip>` +
+			`<a:srcRect l="10"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>` +
+			`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="2000000" cy="1000000"/></a:xfrm><a:prstGeom prst="rect"/><a:ln w="12700"/></pic:spPr>` +
+			`</pic:pic></a:graphicData></a:graphic></wp:anchor></w:drawing></w:r></w:p>`
+	}
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+			xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing"
+			xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main"
+			xmlns:a14="http://schemas.microsoft.com/office/drawing/2010/main"
+			xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture"
+			xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><w:body>` +
+		placeholder("+++logo+++") + placeholder("+++IMAGE signature+++") + placeholder("+++missing+++") +
+		`</w:body></w:document>`)
+	docx, err := buildTestDocxWithParts(content, map[string][]byte{
+		"word/_rels/document.xml.rels": testDocumentRels(`<Relationship Id="rId9" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/image" Target="media/image1.png"/>`),
+		"word/media/image1.png":        testPngImage,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	tpl, err := ParseTemplateBytes(docx)
+	if err != nil {
+		t.Fatalf("ParseTemplateBytes failed: %v", err)
+	}
+	data := ReportData{
+		"logo":      &ImagePars{Extension: ".png", Data: testPngImage, Width: 1, Height: 1, Fit: true, Alt: "Company logo"},
+		"signature": ImagePars{Extension: ".svg", Data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`)},
+		"missing":   nil,
+	}
+
+	// without the option, the placeholders are left untouched
+	out, err := tpl.Render(&data, CreateReportOptions{})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	documentXml, _ := readZipFile(out, "word/document.xml")
+	if strings.Count(string(documentXml), `r:embed="rId9"`) != 3 || !strings.Contains(string(documentXml), `descr="+++logo+++"`) {
+		t.Errorf("Expected the placeholders to be kept by default: %s", documentXml)
+	}
+
+	out, err = tpl.Render(&data, CreateReportOptions{BindPlaceholderImages: true})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	documentXml, _ = readZipFile(out, "word/document.xml")
+	compact := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(documentXml), "><")
+	drawings := regexp.MustCompile(`<wp:anchor .*?</wp:anchor>`).FindAllString(compact, -1)
+	if len(drawings) != 3 {
+		t.Fatalf("Expected 3 drawings, got %d: %s", len(drawings), compact)
+	}
+
+	// the layout of the placeholder is kept, the frame fits the aspect ratio of the image
+	for _, expected := range []string{
+		`<wp:posOffset>540000</wp:posOffset>`,
+		`<wp:wrapSquare wrapText="bothSides"/>`,
+		`<a:srcRect l="10"/>`,
+		`<a:ln w="12700"/>`,
+		`<a14:useLocalDpi val="0"/>`,
+	} {
+		if !strings.Contains(drawings[0], expected) {
+			t.Errorf("Expected %s to be kept: %s", expected, drawings[0])
+		}
+	}
+	if !regexp.MustCompile(`<wp:extent [^>]*cx="1000000"`).MatchString(drawings[0]) ||
+		!regexp.MustCompile(`<a:ext [^>]*cx="1000000"`).MatchString(drawings[0]) {
+		t.Errorf("Expected the frame to fit a square image: %s", drawings[0])
+	}
+	if !regexp.MustCompile(`<wp:docPr [^>]*descr="Company logo"`).MatchString(drawings[0]) {
+		t.Errorf("Expected the alt text of the image: %s", drawings[0])
+	}
+	logoRelId := regexp.MustCompile(`<a:blip r:embed="([^"]+)"`).FindStringSubmatch(drawings[0])
+	if logoRelId == nil || logoRelId[1] == "rId9" {
+		t.Fatalf("Expected the picture to be replaced: %s", drawings[0])
+	}
+	rels, _ := readZipFile(out, "word/_rels/document.xml.rels")
+	if !regexp.MustCompile(`Id="` + logoRelId[1] + `"[^>]*Target="media/[^"]+\.png"|Target="media/[^"]+\.png"[^>]*Id="` + logoRelId[1] + `"`).Match(rels) {
+		t.Errorf("Expected a relationship %s to the image: %s", logoRelId[1], rels)
+	}
+
+	// an SVG is referenced from the extensions of the picture, without the frame being changed
+	if !regexp.MustCompile(`<wp:extent [^>]*cx="2000000"`).MatchString(drawings[1]) ||
+		!strings.Contains(drawings[1], `<asvg:svgBlip`) || strings.Contains(drawings[1], `+++`) {
+		t.Errorf("Expected the SVG image: %s", drawings[1])
+	}
+
+	// no image keeps the placeholder
+	if !strings.Contains(drawings[2], `r:embed="rId9"`) {
+		t.Errorf("Expected the placeholder to be kept: %s", drawings[2])
+	}
+}
+
+func TestImageAutoSize(t *testing.T) {
+	// testPngImage with a resolution of 300 DPI (11811 pixels per meter)
+	pHYs := []byte{0, 0, 0, 9, 'p', 'H', 'Y', 's', 0, 0, 0x2e, 0x23, 0, 0, 0x2e, 0x23, 1}
+	pHYs = binary.BigEndian.AppendUint32(pHYs, crc32.ChecksumIEEE(pHYs[4:]))
+	highDpiImage := slices.Concat(testPngImage[:33], pHYs, testPngImage[33:])
+
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+			<w:p><w:r><w:t>+++IMAGE natural+++</w:t></w:r></w:p>
+			<w:p><w:r><w:t>+++IMAGE width+++</w:t></w:r></w:p>
+			<w:p><w:r><w:t>+++IMAGE box+++</w:t></w:r></w:p>
+			<w:p><w:r><w:t>+++IMAGE highDpi+++</w:t></w:r></w:p>
+			<w:p><w:r><w:t>+++IMAGE svg+++</w:t></w:r></w:p>
+			<w:p><w:r><w:t>+++IMAGE wideSvg+++</w:t></w:r></w:p>
+			<w:sectPr><w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1134" w:right="1134" w:bottom="1134" w:left="1134" w:gutter="0"/></w:sectPr>
+		</w:body></w:document>`)
+	docx, err := buildTestDocx(content)
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	tpl, err := ParseTemplateBytes(docx)
+	if err != nil {
+		t.Fatalf("ParseTemplateBytes failed: %v", err)
+	}
+	data := ReportData{
+		"natural": &ImagePars{Extension: ".png", Data: testPngImage},
+		"width":   &ImagePars{Extension: ".png", Data: testPngImage, Width: 2},
+		"box":     &ImagePars{Extension: ".png", Data: testPngImage, MaxWidth: 3, MaxHeight: 1},
+		"highDpi": &ImagePars{Extension: ".png", Data: highDpiImage},
+		"svg":     &ImagePars{Extension: ".svg", Data: []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="200pt" viewBox="0 0 400 200"/>`)},
+		"wideSvg": &ImagePars{Extension: ".svg", Data: []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="10in" height="5in"/>`)},
+	}
+	out, err := tpl.Render(&data, CreateReportOptions{})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	documentXml, _ := readZipFile(out, "word/document.xml")
+	extents := regexp.MustCompile(`<wp:extent [^>]*>`).FindAllString(string(documentXml), -1)
+	// in EMU: 50 pixels at 96 DPI are 476250, the page content is 9638 twips wide
+	expected := [][2]int{
+		{476250, 476250},
+		{720000, 720000},
+		{360000, 360000},
+		{152400, 152400},
+		{2540000, 1270000},
+		{6120130, 3060065},
+	}
+	if len(extents) != len(expected) {
+		t.Fatalf("Expected %d images, got %d: %s", len(expected), len(extents), documentXml)
+	}
+	size := func(extent string, attr string) int {
+		value, _ := strconv.Atoi(regexp.MustCompile(attr + `="(\d+)"`).FindStringSubmatch(extent)[1])
+		return value
+	}
+	for i, extent := range extents {
+		cx, cy := size(extent, "cx"), size(extent, "cy")
+		if max(cx-expected[i][0], expected[i][0]-cx) > 10 || max(cy-expected[i][1], expected[i][1]-cy) > 10 {
+			t.Errorf("Image %d: expected a size of %v, got %s", i, expected[i], extent)
+		}
+	}
+
+	_, err = tpl.Render(&ReportData{
+		"natural": &ImagePars{Extension: ".png", Data: []byte("not an image")},
+	}, CreateReportOptions{})
+	if err == nil || !strings.Contains(err.Error(), "unknown image size") {
+		t.Errorf("Expected an unknown image size error, got %v", err)
+	}
+}
+
+func TestAnchoredImage(t *testing.T) {
+	content := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+			<w:p><w:r><w:t>+++IMAGE logo+++</w:t></w:r><w:r><w:t>Letterhead</w:t></w:r></w:p>
+			<w:p><w:r><w:t>+++IMAGE signature+++</w:t></w:r></w:p>
+		</w:body></w:document>`)
+	docx, err := buildTestDocx(content)
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+	tpl, err := ParseTemplateBytes(docx)
+	if err != nil {
+		t.Fatalf("ParseTemplateBytes failed: %v", err)
+	}
+	data := ReportData{
+		"logo": &ImagePars{Extension: ".png", Data: testPngImage, Width: 2, Anchor: &ImageAnchor{
+			RelativeTo: "page", X: 1, VerticalAlign: "top", Wrap: "tight", Distance: 0.5, ZOrder: 2,
+		}},
+		"signature": &ImagePars{Extension: ".png", Data: testPngImage, Width: 2, Anchor: &ImageAnchor{Wrap: "behindText"}},
+	}
+	out, err := tpl.Render(&data, CreateReportOptions{})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	documentXml, _ := readZipFile(out, "word/document.xml")
+	compact := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(documentXml), "><")
+	anchors := regexp.MustCompile(`<wp:anchor .*?</wp:anchor>`).FindAllString(compact, -1)
+	if len(anchors) != 2 || strings.Contains(compact, "<wp:inline") {
+		t.Fatalf("Expected 2 anchored images: %s", compact)
+	}
+
+	for _, expected := range []string{
+		`<wp:anchor [^>]*distL="180000"`,
+		`<wp:anchor [^>]*relativeHeight="251658242"`,
+		`<wp:anchor [^>]*behindDoc="0"`,
+		`<wp:positionH relativeFrom="page"><wp:posOffset>360000</wp:posOffset></wp:positionH>`,
+		`<wp:positionV relativeFrom="page"><wp:align>top</wp:align></wp:positionV><wp:extent [^>]*cx="720000"[^>]*/><wp:effectExtent [^>]*/><wp:wrapTight [^>]*><wp:wrapPolygon [^>]*>.*</wp:wrapTight><wp:docPr `,
+	} {
+		if !regexp.MustCompile(expected).MatchString(anchors[0]) {
+			t.Errorf("Expected %s in the logo: %s", expected, anchors[0])
+		}
+	}
+	for _, expected := range []string{
+		`<wp:anchor [^>]*behindDoc="1"`,
+		`<wp:anchor [^>]*distL="114300"`,
+		`<wp:positionH relativeFrom="column"><wp:posOffset>0</wp:posOffset></wp:positionH><wp:positionV relativeFrom="paragraph">`,
+		`<wp:wrapNone/><wp:docPr `,
+	} {
+		if !regexp.MustCompile(expected).MatchString(anchors[1]) {
+			t.Errorf("Expected %s in the signature: %s", expected, anchors[1])
+		}
+	}
+	if !strings.Contains(compact, "Letterhead") {
+		t.Errorf("Expected the text of the paragraph to be kept: %s", compact)
+	}
+
+	_, err = tpl.Render(&ReportData{
+		"logo":      &ImagePars{Extension: ".png", Data: testPngImage, Anchor: &ImageAnchor{Wrap: "around"}},
+		"signature": nil,
+	}, CreateReportOptions{})
+	if err == nil || !strings.Contains(err.Error(), "Invalid anchor Wrap") {
+		t.Errorf("Expected an invalid wrap error, got %v", err)
+	}
+}
@@ -0,0 +1,444 @@
+package godocx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ArFnds/godocx-template/internal"
+)
+
+// Template is a parsed docx template that can be rendered many times.
+//
+// The template archive is read from its source on each render, and the preprocessed main document,
+// headers, footers, footnotes, endnotes and comments are cached per command delimiter. Every call to Render
+// works on its own clone of the cached documents, so a Template can be shared
+// between goroutines.
+type Template struct {
+	source       io.ReaderAt
+	size         int64
+	mainDocument string
+	root         internal.Node
+	contentTypes *internal.NonTextNode
+	extras       map[string]internal.Node // [path]Node
+	extraKinds   map[string]string        // [path]kind
+	footnoteId   int                      // last footnote id of the template
+	commentId    int                      // last comment id of the template
+
+	numberingPath string                // empty if the template has no numbering part
+	numbering     *internal.NonTextNode // list numberings of the template
+	numId         int                   // last list numbering id of the template
+	styles        internal.Styles
+
+	pageContentWidth float32 // in cm, 0 if the template has no page size
+
+	mu       sync.Mutex
+	prepared map[Delimiters]*preparedTemplate
+}
+
+type preparedTemplate struct {
+	root       internal.Node
+	extras     map[string]internal.Node // [path]Node
+	parseCache *internal.ParseCache     // expressions of the commands, parsed on their first render
+}
+
+// ParseTemplate reads and parses the template file at templatePath.
+// The returned Template can then be rendered with Render.
+func ParseTemplate(templatePath string) (*Template, error) {
+	data, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplateBytes(data)
+}
+
+// ParseTemplateFS reads and parses the template file name from fsys,
+// e.g. an embed.FS.
+func ParseTemplateFS(fsys fs.FS, name string) (*Template, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplateBytes(data)
+}
+
+// ParseTemplateBytes parses a template from the content of a docx file.
+// The slice must not be modified afterwards.
+func ParseTemplateBytes(data []byte) (*Template, error) {
+	return ParseTemplateFrom(bytes.NewReader(data), int64(len(data)))
+}
+
+// ParseTemplateFrom parses a template from a docx file of the given size.
+// The source is read again on each render, so it must stay readable
+// (and unchanged) for as long as the Template is used.
+func ParseTemplateFrom(r io.ReaderAt, size int64) (tpl *Template, err error) {
+	zip, err := internal.NewZipArchiveFromReader(r, size, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, zip.Close())
+	}()
+
+	parseResult, err := internal.ParseTemplate(zip)
+	if err != nil {
+		return nil, fmt.Errorf("ParseTemplate failed: %w", err)
+	}
+
+	tpl = &Template{
+		source:       r,
+		size:         size,
+		mainDocument: parseResult.MainDocument,
+		root:         parseResult.Root,
+		contentTypes: parseResult.ContentTypes,
+		extras:       parseResult.Extras,
+		extraKinds:   parseResult.ExtraKinds,
+		prepared:     make(map[Delimiters]*preparedTemplate),
+
+		numberingPath: parseResult.NumberingPath,
+		numbering:     parseResult.Numbering,
+		styles:        parseResult.Styles,
+
+		pageContentWidth: internal.PageContentWidth(parseResult.Root),
+	}
+	if tpl.numbering != nil {
+		tpl.numId = internal.MaxNumberingId(tpl.numbering, "w:numId")
+	}
+	for extraPath, kind := range parseResult.ExtraKinds {
+		switch kind {
+		case internal.FOOTNOTES_KIND:
+			tpl.footnoteId = internal.MaxNoteId(parseResult.Extras[extraPath])
+		case internal.COMMENTS_KIND:
+			tpl.commentId = internal.MaxNoteId(parseResult.Extras[extraPath])
+		}
+	}
+	return tpl, nil
+}
+
+// prepare returns the preprocessed documents for the given delimiters,
+// preprocessing them on first use.
+func (t *Template) prepare(delimiters Delimiters) (*preparedTemplate, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if prepared, ok := t.prepared[delimiters]; ok {
+		return prepared, nil
+	}
+
+	// PreprocessTemplate modifies the tree, keep the parsed one untouched
+	root, err := internal.PreprocessTemplate(internal.CloneNode(t.root), delimiters)
+	if err != nil {
+		return nil, fmt.Errorf("PreprocessTemplate failed: %w", err)
+	}
+	extras := make(map[string]internal.Node, len(t.extras))
+	for extraPath, extraNode := range t.extras {
+		extras[extraPath], err = internal.PreprocessTemplate(internal.CloneNode(extraNode), delimiters)
+		if err != nil {
+			return nil, fmt.Errorf("PreprocessTemplate failed: %w", err)
+		}
+	}
+
+	prepared := &preparedTemplate{
+		root:       root,
+		extras:     extras,
+		parseCache: internal.NewParseCache(),
+	}
+	t.prepared[delimiters] = prepared
+	return prepared, nil
+}
+
+// Render generates a report document from the template and the given data,
+// and returns the final document as a byte slice.
+// It is safe to call Render from several goroutines at once.
+func (t *Template) Render(data *ReportData, options CreateReportOptions) ([]byte, error) {
+	return t.RenderContext(context.Background(), data, options)
+}
+
+// RenderContext is like Render, but stops with the context error
+// as soon as ctx is cancelled or its deadline is exceeded.
+func (t *Template) RenderContext(ctx context.Context, data *ReportData, options CreateReportOptions) ([]byte, error) {
+	return t.render(ctx, reportDataRoot(data), options)
+}
+
+// RenderTo generates a report document from the template and the given data,
+// and writes the final document to w.
+// Nothing is written to w if an error occurs before the document is assembled.
+func (t *Template) RenderTo(w io.Writer, data *ReportData, options CreateReportOptions) error {
+	return t.RenderToContext(context.Background(), w, data, options)
+}
+
+// RenderToContext is like RenderTo, but stops with the context error
+// as soon as ctx is cancelled or its deadline is exceeded.
+func (t *Template) RenderToContext(ctx context.Context, w io.Writer, data *ReportData, options CreateReportOptions) error {
+	return t.renderTo(ctx, w, reportDataRoot(data), options)
+}
+
+// RenderT renders tpl like Template.Render, but the data can be any Go value,
+// e.g. a struct or a pointer to a struct.
+// Paths in the template resolve through map keys and exported struct fields,
+// named by their `docx:"name"` tag or by their name.
+func RenderT[T any](tpl *Template, data T, options CreateReportOptions) ([]byte, error) {
+	return tpl.render(context.Background(), data, options)
+}
+
+// RenderContextT is like RenderT, but stops with the context error
+// as soon as ctx is cancelled or its deadline is exceeded.
+func RenderContextT[T any](ctx context.Context, tpl *Template, data T, options CreateReportOptions) ([]byte, error) {
+	return tpl.render(ctx, data, options)
+}
+
+func reportDataRoot(data *ReportData) any {
+	if data == nil {
+		return nil
+	}
+	return *data
+}
+
+func (t *Template) render(ctx context.Context, data any, options CreateReportOptions) ([]byte, error) {
+	var outBuffer bytes.Buffer
+	err := t.renderTo(ctx, &outBuffer, data, options)
+	if err != nil {
+		return nil, err
+	}
+	return outBuffer.Bytes(), nil
+}
+
+// partOfKind returns the path of the template part of the given kind, e.g. footnotes, or an empty string
+func (t *Template) partOfKind(kind string) string {
+	for extraPath, extraKind := range t.extraKinds {
+		if extraKind == kind {
+			return extraPath
+		}
+	}
+	return ""
+}
+
+// processResources writes the images, htmls and links of a rendered document part,
+// and adds their relationships to the part rels.
+func processResources(result *internal.ReportOutput, documentComponent string, zip *internal.ZipArchive) error {
+	err := internal.ProcessImages(result.Images, documentComponent, zip)
+	if err != nil {
+		return fmt.Errorf("ProcessImages failed: %w", err)
+	}
+	err = internal.ProcessHtmls(result.Htmls, documentComponent, zip)
+	if err != nil {
+		return fmt.Errorf("ProcessHtmls failed: %w", err)
+	}
+	err = internal.ProcessLinks(result.Links, documentComponent, zip)
+	if err != nil {
+		return fmt.Errorf("ProcessLinks failed: %w", err)
+	}
+	return nil
+}
+
+func (t *Template) renderTo(ctx context.Context, w io.Writer, data any, options CreateReportOptions) (err error) {
+	setDefaultOptions(&options)
+	session := internal.NewRenderSession(ctx, 73086257)
+	//TODO ^ max id
+	session.SetNoteIds(t.footnoteId, t.commentId)
+	session.SetNumId(t.numId)
+	session.SetStyles(t.styles)
+	session.SetPageContentWidth(t.pageContentWidth)
+
+	prepared, err := t.prepare(*options.CmdDelimiter)
+	if err != nil {
+		return err
+	}
+	session.SetParseCache(prepared.parseCache)
+
+	xmlOptions := internal.XmlOptions{
+		LiteralXmlDelimiter: options.LiteralXmlDelimiter,
+	}
+
+	zip, err := internal.NewZipArchiveFromReader(t.source, t.size, w)
+	if err != nil {
+		return err
+	}
+	doCleanupOnDefer := true
+	defer func() {
+		if doCleanupOnDefer { // only do cleanup on early returns, without writing an empty zip to w
+			errOnDiscard := zip.Discard()
+			err = errors.Join(err, errOnDiscard)
+		}
+	}()
+
+	// The main document, then the additional headers, footers, footnotes, endnotes and comments,
+	// in a stable order for the image and shape ids
+	partPaths := append([]string{internal.TEMPLATE_PATH + "/" + t.mainDocument}, slices.Sorted(maps.Keys(prepared.extras))...)
+	results := make(map[string]*internal.ReportOutput, len(partPaths))
+	footnotes := []internal.Note{}
+	comments := []internal.Note{}
+	numberings := []internal.Numbering{}
+	for i, partPath := range partPaths {
+		template := prepared.root
+		if i > 0 {
+			template = prepared.extras[partPath]
+		}
+		result, err := internal.ProduceReport(data, internal.CloneNode(template), internal.NewContext(session, options))
+		if err != nil {
+			return fmt.Errorf("ProduceReport failed: %w", err)
+		}
+		results[partPath] = result
+		footnotes = append(footnotes, result.Footnotes...)
+		comments = append(comments, result.Comments...)
+		numberings = append(numberings, result.Numberings...)
+	}
+
+	contentTypes := internal.CloneNode(t.contentTypes)
+	contentTypesChanged := false
+
+	// addPart adds a part created by the render, with its relationship and its content type
+	addPart := func(kind string) (*internal.ReportOutput, error) {
+		partPath, root, err := internal.NewPart(kind, t.mainDocument)
+		if err != nil {
+			return nil, err
+		}
+		err = internal.AddPartRelationship(kind, partPath, t.mainDocument, zip)
+		if err != nil {
+			return nil, fmt.Errorf("AddPartRelationship failed: %w", err)
+		}
+		internal.AddChild(contentTypes, internal.NewNonTextNode("Override", map[string]string{
+			"PartName":    "/" + partPath,
+			"ContentType": internal.PartContentType(kind),
+		}, nil))
+		contentTypesChanged = true
+		partPaths = append(partPaths, partPath)
+		results[partPath] = &internal.ReportOutput{Report: root}
+		return results[partPath], nil
+	}
+
+	// Notes of the FOOTNOTE and COMMENT commands, in a new part if the template has none
+	for _, notes := range []struct {
+		kind  string
+		notes []internal.Note
+	}{{internal.FOOTNOTES_KIND, footnotes}, {internal.COMMENTS_KIND, comments}} {
+		if len(notes.notes) == 0 {
+			continue
+		}
+		output := results[t.partOfKind(notes.kind)]
+		if output == nil {
+			output, err = addPart(notes.kind)
+			if err != nil {
+				return err
+			}
+		}
+		internal.AddNotes(output.Report, notes.kind, notes.notes, xmlOptions.LiteralXmlDelimiter)
+	}
+
+	// Numberings of the lists converted from HTML
+	if len(numberings) > 0 {
+		var output *internal.ReportOutput
+		if t.numbering != nil {
+			output = &internal.ReportOutput{Report: internal.CloneNode(t.numbering)}
+			partPaths = append(partPaths, t.numberingPath)
+			results[t.numberingPath] = output
+		} else {
+			output, err = addPart(internal.NUMBERING_KIND)
+			if err != nil {
+				return err
+			}
+		}
+		internal.AddNumberings(output.Report, numberings)
+	}
+
+	numImages := 0
+	numHtmls := 0
+	numCharts := 0
+	for _, partPath := range partPaths {
+		result := results[partPath]
+		slog.Debug(fmt.Sprintf("Writing %s...", partPath))
+		zip.SetFile(partPath, internal.BuildXml(result.Report, xmlOptions, ""))
+
+		numImages += len(result.Images)
+		numHtmls += len(result.Htmls)
+		numCharts += len(result.Charts)
+		documentComponent := strings.TrimPrefix(partPath, internal.TEMPLATE_PATH+"/")
+		err = processResources(result, documentComponent, zip)
+		if err != nil {
+			return err
+		}
+		chartPaths, err := internal.ProcessCharts(result.Charts, documentComponent, zip)
+		if err != nil {
+			return fmt.Errorf("ProcessCharts failed: %w", err)
+		}
+		for _, chartPath := range chartPaths {
+			internal.AddChild(contentTypes, internal.NewNonTextNode("Override", map[string]string{
+				"PartName":    "/" + chartPath,
+				"ContentType": internal.CHART_CONTENT_TYPE,
+			}, nil))
+		}
+		err = internal.UpdateCharts(result.ChartUpdates, documentComponent, zip)
+		if err != nil {
+			return fmt.Errorf("UpdateCharts failed: %w", err)
+		}
+	}
+
+	if numHtmls > 0 || numImages > 0 || numCharts > 0 {
+		slog.Debug("Completing [Content_Types].xml...")
+
+		children := contentTypes.Children()
+		ensureContentType := func(extension string, contentType string) {
+			containsExtension := slices.ContainsFunc(children, func(n internal.Node) bool {
+				nonTextNode, isNonTextNode := n.(*internal.NonTextNode)
+				return isNonTextNode && nonTextNode.Attrs["Extension"] == extension
+			})
+			if containsExtension {
+				return
+			}
+			internal.AddChild(contentTypes, internal.NewNonTextNode("Default", map[string]string{"Extension": extension, "ContentType": contentType}, nil))
+		}
+		if numImages > 0 {
+			slog.Debug("Completing [Content_Types].xml for IMAGES...")
+			ensureContentType("png", "image/png")
+			ensureContentType("jpg", "image/jpeg")
+			ensureContentType("jpeg", "image/jpeg")
+			ensureContentType("gif", "image/gif")
+			ensureContentType("bmp", "image/bmp")
+			ensureContentType("svg", "image/svg+xml")
+		}
+		if numHtmls > 0 {
+			slog.Debug("Completing [Content_Types].xml for HTML...")
+			ensureContentType("html", "text/html")
+		}
+		if numCharts > 0 {
+			slog.Debug("Completing [Content_Types].xml for CHARTS...")
+			ensureContentType("xlsx", internal.WORKBOOK_CONTENT_TYPE)
+		}
+		contentTypesChanged = true
+	}
+	if contentTypesChanged {
+		finalContentTypesXml := internal.BuildXml(contentTypes, xmlOptions, "")
+		zip.SetFile(CONTENT_TYPES_PATH, finalContentTypesXml)
+	}
+
+	if maxSize := options.MaximumOutputSize; maxSize > 0 {
+		if size := zip.UncompressedSize(); size > maxSize {
+			return &OutputSizeExceededError{Limit: maxSize, Size: size}
+		}
+	}
+	if err := session.Err(); err != nil {
+		return err
+	}
+
+	err = zip.Assemble()
+	if err != nil {
+		return fmt.Errorf("Error assembling zip: %w", err)
+	}
+
+	err = zip.Close()
+	doCleanupOnDefer = false // zip was closed here by code path, no special cleanup needed
+	if err != nil {
+		return fmt.Errorf("Error closing zip: %w", err)
+	}
+
+	return nil
+}
@@ -6,9 +6,50 @@ type Delimiters = internal.Delimiters
 type ReportData = internal.ReportData
 type ImagePars = internal.ImagePars
 type LinkPars = internal.LinkPars
+
+// ImageAnchor floats an image of an IMAGE command, with its position and text wrapping
+type ImageAnchor = internal.ImageAnchor
+
+// TablePars is the value of a TABLE command, and TableCell a cell with its own alignment
+type TablePars = internal.TablePars
+type TableCell = internal.TableCell
+
+// ChartPars is the value of a CHART command, with the values of each ChartSeries by category
+type ChartPars = internal.ChartPars
+type ChartSeries = internal.ChartSeries
+
+// ChartData replaces the categories and series of a chart of the template,
+// bound by its alt text or title, e.g. chart:salesByMonth
+type ChartData = internal.ChartData
+
+// CommentPars is the value of a COMMENT command: the text of the comment and its author
+type CommentPars = internal.CommentPars
 type CreateReportOptions = internal.CreateReportOptions
 
+// RichText is a value of INS made of spans with their own formatting, e.g.
+// RichText{{Text: "Total: "}, {Text: "42", Bold: true, Color: "FF0000"}}
+type RichText = internal.RichText
+type TextSpan = internal.TextSpan
+
 type VarValue = internal.VarValue
 
+// MapEntry is the loop variable of a FOR loop over a map or an iter.Seq2,
+// e.g. +++$entry.key+++ and +++$entry.value+++
+type MapEntry = internal.MapEntry
+
+// Group is the loop variable of a FOR loop with a GROUP BY clause,
+// e.g. +++$group.key+++ and +++FOR item IN $group.items+++
+type Group = internal.Group
+
+// Errors returned when a limit of CreateReportOptions is exceeded
+type WalkingDepthExceededError = internal.WalkingDepthExceededError
+type LoopIterationsExceededError = internal.LoopIterationsExceededError
+type OutputSizeExceededError = internal.OutputSizeExceededError
+type ImageBytesExceededError = internal.ImageBytesExceededError
+
+// StyleNotFoundError is returned when a STYLE or CSTYLE command gives a style
+// that isn't defined in the template
+type StyleNotFoundError = internal.StyleNotFoundError
+
 // map[string]func(args ...any) string
 type Functions = internal.Functions